@@ -2,8 +2,22 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"text/template"
 	"time"
+	"unicode"
+
+	"paperlesslink/transform"
+	"paperlesslink/trash"
 )
 
 // AfterUpload defines what to do with a file after a successful upload.
@@ -12,52 +26,1056 @@ type AfterUpload string
 const (
 	AfterUploadDelete AfterUpload = "delete"
 	AfterUploadBackup AfterUpload = "backup"
+
+	// AfterUploadTrash moves the file to the desktop trash or recycle bin,
+	// from where it can be restored.
+	AfterUploadTrash AfterUpload = "trash"
+
+	// AfterUploadKeep leaves the file where it is; the state file keeps it
+	// from being uploaded again.
+	AfterUploadKeep AfterUpload = "keep"
+)
+
+// OnDuplicate defines what to do with a file Paperless rejects as a duplicate.
+type OnDuplicate string
+
+const (
+	OnDuplicateSkip   OnDuplicate = "skip"
+	OnDuplicateError  OnDuplicate = "error"
+	OnDuplicateBackup OnDuplicate = "backup"
+)
+
+// NotifyOn selects which uploads are reported to NotifyURL.
+type NotifyOn string
+
+const (
+	NotifyOnAll     NotifyOn = "all"
+	NotifyOnFailure NotifyOn = "failure"
+)
+
+// BackupSubdirs selects how backups are partitioned below BackupDir.
+type BackupSubdirs string
+
+const (
+	BackupSubdirsNone       BackupSubdirs = "none"
+	BackupSubdirsUploadDate BackupSubdirs = "upload-date"
+	BackupSubdirsModTime    BackupSubdirs = "mtime"
+)
+
+// BackupMode selects whether backing up a file moves or copies it.
+type BackupMode string
+
+const (
+	BackupModeMove BackupMode = "move"
+	// BackupModeCopy leaves the original in the watch directory; the state
+	// file keeps it from being uploaded again.
+	BackupModeCopy BackupMode = "copy"
 )
 
-// Config holds all runtime configuration for PaperlessLink.
+// WatchMode selects which mechanism detects new files.
+type WatchMode string
+
+const (
+	WatchModeFsnotify WatchMode = "fsnotify"
+	WatchModePoll     WatchMode = "poll"
+	WatchModeBoth     WatchMode = "both"
+)
+
+// LogFormat selects the log output encoding.
+type LogFormat string
+
+const (
+	LogFormatJSON LogFormat = "json"
+	LogFormatText LogFormat = "text"
+)
+
+// LogOutput selects the console stream logs are written to.
+type LogOutput string
+
+const (
+	LogOutputStdout LogOutput = "stdout"
+	LogOutputStderr LogOutput = "stderr"
+)
+
+// Extensions is a set of lower-cased file extensions without leading dot.
+type Extensions map[string]struct{}
+
+// String returns the extensions as a sorted, comma-separated list.
+func (e Extensions) String() string {
+	return strings.Join(slices.Sorted(maps.Keys(e)), ",")
+}
+
+// Metadata is a set of document metadata references. Each entry is either a
+// numeric Paperless ID or a name resolved via the API.
+type Metadata struct {
+	Tags          []string `yaml:"tags"`
+	Correspondent string   `yaml:"correspondent"`
+	DocumentType  string   `yaml:"document_type"`
+	StoragePath   string   `yaml:"storage_path"`
+
+	// CustomFields holds "ref=value" entries, where ref is a custom field ID
+	// or name; an entry without "=" adds the field without a value.
+	CustomFields []string `yaml:"custom_field"`
+
+	// Owner is a user ID or username; empty leaves the document owned by
+	// the token's user. The lists grant view and edit permissions to further
+	// users and groups.
+	Owner      string   `yaml:"owner"`
+	ViewUsers  []string `yaml:"view_users"`
+	ViewGroups []string `yaml:"view_groups"`
+	EditUsers  []string `yaml:"edit_users"`
+	EditGroups []string `yaml:"edit_groups"`
+}
+
+// Merge returns m with every non-empty field of override applied on top.
+func (m Metadata) Merge(override Metadata) Metadata {
+	if len(override.Tags) > 0 {
+		m.Tags = override.Tags
+	}
+	if override.Correspondent != "" {
+		m.Correspondent = override.Correspondent
+	}
+	if override.DocumentType != "" {
+		m.DocumentType = override.DocumentType
+	}
+	if override.StoragePath != "" {
+		m.StoragePath = override.StoragePath
+	}
+	if len(override.CustomFields) > 0 {
+		m.CustomFields = override.CustomFields
+	}
+	if override.Owner != "" {
+		m.Owner = override.Owner
+	}
+	for _, l := range []struct{ dst, src *[]string }{
+		{&m.ViewUsers, &override.ViewUsers},
+		{&m.ViewGroups, &override.ViewGroups},
+		{&m.EditUsers, &override.EditUsers},
+		{&m.EditGroups, &override.EditGroups},
+	} {
+		if len(*l.src) > 0 {
+			*l.dst = *l.src
+		}
+	}
+	return m
+}
+
+// ParseCustomField splits a custom field entry into the field reference and
+// its value. hasValue is false for an entry without "=".
+func ParseCustomField(entry string) (ref, value string, hasValue bool, err error) {
+	ref, value, hasValue = strings.Cut(entry, "=")
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return "", "", false, errors.New("missing custom field ID or name before '='")
+	}
+	return ref, value, hasValue, nil
+}
+
+// Rule assigns metadata to files from matching directories, overriding the
+// global defaults. Rules are only read from the config file.
+type Rule struct {
+	// Dir is a directory path or filepath.Match glob. It matches when it
+	// equals either the watch directory or the directory holding the file.
+	Dir string `yaml:"dir"`
+
+	// Target names the entry of Targets to upload to; empty uses -url.
+	Target string `yaml:"target"`
+
+	Metadata `yaml:",inline"`
+}
+
+// Target is an additional Paperless instance that rules can send files to.
+// Targets are only read from the config file.
+type Target struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+
+	// TokenFile is read into Token by LoadTokenFile, as for the default
+	// instance.
+	TokenFile string `yaml:"token_file"`
+}
+
+// Config holds all runtime configuration for PaperlessLink. The yaml tags
+// name the keys accepted in a -config file; they are the flag names with
+// underscores instead of dashes and also derive the environment variable
+// names (see FromEnv).
 type Config struct {
-	WatchDir     string
-	PaperlessURL string
-	Token        string
+	// WatchDirs lists every directory to watch; each gets its own watcher.
+	WatchDirs    []string `yaml:"dir"`
+	PaperlessURL string   `yaml:"url"`
+	Token        string   `yaml:"token"`
+
+	// TokenFile is read into Token by LoadTokenFile; it is mutually exclusive
+	// with Token and suits Docker secrets or systemd LoadCredential.
+	TokenFile string `yaml:"token_file"`
+
+	// APIPath is the path of the REST API below PaperlessURL and every
+	// target URL, "/api" unless a proxy maps it elsewhere.
+	APIPath string `yaml:"api_path"`
 
 	// AllowedExts is the set of lower-cased extensions (without leading dot)
 	// that are accepted. Empty means all extensions are accepted.
-	AllowedExts map[string]struct{}
+	AllowedExts Extensions `yaml:"ext"`
+
+	// Include and Exclude are filepath.Match globs on the file name. With
+	// Include set only matching files are uploaded; Exclude always wins.
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+
+	// NoIgnoreTemp also uploads hidden files and files with temporary-file
+	// suffixes, which are skipped by default.
+	NoIgnoreTemp bool `yaml:"no_ignore_temp"`
+
+	// TitleTemplate is a text/template rendering the document title; see
+	// uploader.TitleData for the available fields. Empty uses the file stem.
+	TitleTemplate string `yaml:"title_template"`
+
+	// TitleTransform lists the clean-up steps applied, in order, to the file
+	// name stem before it becomes the title or the template's .Stem; see
+	// transform.Parse. Empty leaves the stem as it is.
+	TitleTransform []string `yaml:"title_transform"`
+
+	// NoteTemplate is a text/template rendering a note added to the document
+	// once it is consumed; see uploader.NoteData. Empty adds no note.
+	NoteTemplate string `yaml:"note_template"`
+
+	// DateRegex is matched against the file name; its first capture group is
+	// parsed with the Go time layout DateLayout and sent as the created date.
+	DateRegex  string `yaml:"date_regex"`
+	DateLayout string `yaml:"date_layout"`
+
+	// UseMTime sends the file modification time as the created date when
+	// DateRegex is unset or does not match.
+	UseMTime bool `yaml:"use_mtime"`
+
+	// Tags are attached to every uploaded document. Each entry is either a
+	// numeric Paperless tag ID or a tag name resolved via the API.
+	Tags []string `yaml:"tags"`
+
+	// ConsumptionTag is a tag ID or name attached to every document even when
+	// a rule replaces Tags, so that a Paperless workflow triggered by it runs
+	// for everything this instance uploads.
+	ConsumptionTag string `yaml:"consumption_tag"`
+
+	// Correspondent and DocumentType are optional IDs or names, resolved the
+	// same way as Tags.
+	Correspondent string `yaml:"correspondent"`
+	DocumentType  string `yaml:"document_type"`
+
+	// StoragePath is an optional storage path ID or name.
+	StoragePath string `yaml:"storage_path"`
+
+	// CustomFields sets custom fields as "ref=value" entries; see Metadata.
+	CustomFields []string `yaml:"custom_field"`
+
+	// Owner and the permission lists hold user and group IDs or names; see
+	// Metadata.
+	Owner      string   `yaml:"owner"`
+	ViewUsers  []string `yaml:"view_users"`
+	ViewGroups []string `yaml:"view_groups"`
+	EditUsers  []string `yaml:"edit_users"`
+	EditGroups []string `yaml:"edit_groups"`
+
+	// ASNStart enables sequential archive serial numbers, starting here; the
+	// counter lives in StateFile. Zero disables it.
+	ASNStart int `yaml:"asn_start"`
+
+	// ASNRegex is matched against the file name; its capture group is sent
+	// as the archive serial number, taking precedence over ASNStart.
+	ASNRegex string `yaml:"asn_regex"`
 
-	RenameToUUID bool
-	AfterUpload  AfterUpload
-	BackupDir    string
+	// CreateMissingMetadata creates tags, correspondents and document types
+	// that are referenced by name but do not exist in Paperless yet.
+	CreateMissingMetadata bool `yaml:"create_missing_metadata"`
 
-	LogFile      string
-	PollInterval time.Duration
+	// Rules override the metadata above for files from matching directories;
+	// the first matching rule wins.
+	Rules []Rule `yaml:"rules"`
+
+	// Targets are named Paperless instances besides the default one; rules
+	// route files to them.
+	Targets map[string]Target `yaml:"targets"`
+
+	// VerifyMIME sniffs each file's content and rejects files whose type
+	// contradicts their extension.
+	VerifyMIME bool `yaml:"verify_mime"`
+
+	// AllowedMIME lists the content types, such as "application/pdf" or
+	// "image/*", that files may have when sniffed; others are rejected
+	// whatever their extension. Empty allows every type.
+	AllowedMIME []string `yaml:"allowed_mime"`
+
+	// MIMEOverrides are "ext=type" entries setting the content type sent for
+	// an extension, ahead of the built-in and system types.
+	MIMEOverrides []string `yaml:"mime_override"`
+
+	// BufferUpload builds each upload's request body in memory, so it is
+	// sent with a Content-Length rather than chunked. Memory use grows with
+	// the file size, times Concurrency.
+	BufferUpload bool `yaml:"buffer_upload"`
+
+	// GzipUpload compresses the request body of uploads whose format
+	// compresses well and sends it with Content-Encoding: gzip. The server
+	// in front of Paperless must decompress it; Paperless itself does not.
+	GzipUpload bool `yaml:"gzip_upload"`
+
+	// DryRun logs what each upload and post-upload action would do without
+	// contacting Paperless or touching the files.
+	DryRun bool `yaml:"dry_run"`
+
+	RenameToUUID bool        `yaml:"rename_uuid"`
+	AfterUpload  AfterUpload `yaml:"after_upload"`
+	BackupDir    string      `yaml:"backup_dir"`
+
+	// PreserveFilename sends the original file name to Paperless, which
+	// records it as the document's original filename, when RenameToUUID
+	// uploads a UUID-named copy.
+	PreserveFilename bool `yaml:"preserve_filename"`
+
+	// BackupSubdirs sorts backups into YYYY/MM subdirectories of BackupDir by
+	// upload time or file modification time.
+	BackupSubdirs BackupSubdirs `yaml:"backup_subdirs"`
+
+	// BackupMode moves files into BackupDir or copies them there, leaving
+	// the original in place.
+	BackupMode BackupMode `yaml:"backup_mode"`
+
+	// StateFile persists checksums of uploaded files so they are never
+	// uploaded twice. Empty disables the check.
+	StateFile string `yaml:"state_file"`
+
+	// Manifest is a file every processed file is appended to as a JSON
+	// line, as a durable import ledger. Empty disables it.
+	Manifest string `yaml:"manifest"`
+
+	// OfflineQueue keeps files that could not be uploaded because Paperless
+	// was unreachable in a queue in StateFile and retries them once it is
+	// back, instead of failing them.
+	OfflineQueue bool `yaml:"offline_queue"`
+
+	// SkipExisting asks Paperless before each upload whether a document with
+	// the same content already exists and, if so, skips straight to the
+	// post-upload action.
+	SkipExisting bool `yaml:"skip_existing"`
+
+	// OnDuplicate handles files Paperless rejects as duplicates: skip runs the
+	// post-upload action, backup moves them to BackupDir and error treats
+	// them like any other failed upload.
+	OnDuplicate OnDuplicate `yaml:"on_duplicate"`
+
+	// ErrorDir receives files whose upload failed for good, each with a
+	// ".error.txt" sidecar. Empty leaves failed files in place.
+	ErrorDir string `yaml:"error_dir"`
+
+	// MaxRetries is the number of additional attempts after a failed upload;
+	// RetryBaseDelay is the initial backoff, doubled on every retry.
+	MaxRetries     int           `yaml:"max_retries"`
+	RetryBaseDelay time.Duration `yaml:"retry_base_delay"`
+
+	// CircuitThreshold is the number of uploads in a row that may find a
+	// Paperless instance unreachable before further uploads to it fail at
+	// once for CircuitCooldown. Zero disables the circuit breaker.
+	CircuitThreshold int           `yaml:"circuit_threshold"`
+	CircuitCooldown  time.Duration `yaml:"circuit_cooldown"`
+
+	// ConfirmConsumption waits for the Paperless consumption task to succeed
+	// before the post-upload action runs, giving up after ConsumptionTimeout.
+	ConfirmConsumption bool          `yaml:"confirm_consumption"`
+	ConsumptionTimeout time.Duration `yaml:"consumption_timeout"`
+
+	// Proxy is the URL of an HTTP(S) or SOCKS5 proxy for all requests to
+	// Paperless, overriding HTTP_PROXY/HTTPS_PROXY/NO_PROXY.
+	Proxy string `yaml:"proxy"`
+
+	// UserAgent is sent with every request to Paperless.
+	UserAgent string `yaml:"user_agent"`
+
+	// HTTPTimeout bounds each request to Paperless, including the body
+	// upload; zero is no limit.
+	HTTPTimeout time.Duration `yaml:"http_timeout"`
+
+	// DialTimeout bounds establishing a TCP connection to Paperless or the
+	// proxy. ResponseHeaderTimeout bounds the wait for the response headers
+	// once the request, body included, is sent; zero is no limit. Unlike
+	// HTTPTimeout, neither depends on how long a large upload takes.
+	DialTimeout           time.Duration `yaml:"dial_timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout"`
+
+	// CACert is a PEM file with additional root CAs for a self-signed or
+	// private-CA Paperless. InsecureSkipVerify disables verification entirely.
+	CACert             string `yaml:"ca_cert"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+
+	// ClientCert and ClientKey are PEM files with the certificate and key
+	// presented to a Paperless, or its reverse proxy, that requires mutual
+	// TLS.
+	ClientCert string `yaml:"client_cert"`
+	ClientKey  string `yaml:"client_key"`
+
+	// BasicAuth, as "user:password", authenticates with HTTP basic auth
+	// instead of an API token, for a reverse proxy that requires it or
+	// Paperless's own basic auth. It cannot be layered with a token: a
+	// request carries a single Authorization header, and proxies such as
+	// nginx reject a second one.
+	BasicAuth string `yaml:"basic_auth"`
+
+	// Concurrency is the number of uploads that may run in parallel.
+	Concurrency int `yaml:"concurrency"`
+
+	// QueueSize is how many detected files each watcher hands on ahead of
+	// the uploads; up to 64 times as many more wait inside the watcher,
+	// which keeps reading events, and beyond that they are picked up by a
+	// rescan once the uploads have caught up.
+	QueueSize int `yaml:"queue_size"`
+
+	// RateLimit caps uploads per minute across all workers; zero is unlimited.
+	RateLimit float64 `yaml:"rate_limit"`
+
+	// ShutdownTimeout is how long in-flight and queued uploads may continue
+	// after SIGINT/SIGTERM before they are cancelled.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// NotifyURL receives a JSON POST after each upload, or only after failed
+	// ones with NotifyOn=failure. Empty disables notifications.
+	NotifyURL string   `yaml:"notify_url"`
+	NotifyOn  NotifyOn `yaml:"notify_on"`
+
+	// SkipStartupCheck starts without first checking that Paperless is
+	// reachable and accepts the token, for when it comes up later.
+	SkipStartupCheck bool `yaml:"skip_startup_check"`
+
+	// HealthAddr serves /healthz and /readyz probes on this address; Paperless
+	// is checked every HealthInterval for readiness. Empty disables it.
+	HealthAddr     string        `yaml:"health_addr"`
+	HealthInterval time.Duration `yaml:"health_interval"`
+
+	// PprofAddr serves the net/http/pprof profiling handlers on this
+	// address. Empty, the default, disables it.
+	PprofAddr string `yaml:"pprof_addr"`
+
+	LogFile   string    `yaml:"log_file"`
+	LogLevel  string    `yaml:"log_level"` // debug | info | warn | error
+	LogFormat LogFormat `yaml:"log_format"`
+
+	// LogOutput is the console stream, stdout or stderr. LogQuiet only
+	// writes warnings and errors there; LogFile still gets every level.
+	LogOutput LogOutput `yaml:"log_output"`
+	LogQuiet  bool      `yaml:"quiet"`
+
+	// LogMaxSizeMB rotates LogFile at this size (0 = never); LogMaxBackups
+	// rotated files are kept.
+	LogMaxSizeMB  int `yaml:"log_max_size_mb"`
+	LogMaxBackups int `yaml:"log_max_backups"`
+
+	// LogCompress gzips rotated log files.
+	LogCompress bool `yaml:"log_compress"`
+
+	// Debounce is the quiet period after the last event for a file before it
+	// is checked and uploaded.
+	Debounce time.Duration `yaml:"debounce"`
+
+	WatchMode    WatchMode     `yaml:"watch_mode"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+
+	// CloseWrite uses inotify close-write events on Linux to handle a file
+	// as soon as its writer closes it, instead of Debounce after its last
+	// fsnotify event.
+	CloseWrite bool `yaml:"close_write"`
+
+	// ProcessExisting queues files already in the watch directories at startup.
+	ProcessExisting bool `yaml:"process_existing"`
+
+	// Recursive watches subdirectories of the watch directories as well.
+	Recursive bool `yaml:"recursive"`
+
+	// FollowSymlinks uploads symlinked files and, with Recursive, watches
+	// symlinked directories unless their target is already watched.
+	FollowSymlinks bool `yaml:"follow_symlinks"`
+
+	// Once uploads the files currently in the watch directories and exits
+	// instead of watching.
+	Once bool `yaml:"once"`
+
+	// StabilityInterval is how long a file's size must stay unchanged before
+	// it is uploaded. Zero disables the check.
+	StabilityInterval time.Duration `yaml:"stability_interval"`
+
+	// MinAge is how far in the past a file's modification time must be
+	// before it is uploaded. Zero disables the check.
+	MinAge time.Duration `yaml:"min_age"`
+
+	// FileWaitTimeout is how long a file that cannot be accessed yet is
+	// retried before it is skipped.
+	FileWaitTimeout time.Duration `yaml:"file_wait_timeout"`
 }
 
-// Validate checks that required fields are present and combinations are valid.
+// Validate checks that required fields are present and combinations are
+// valid. It also normalises the Paperless URLs, dropping trailing slashes.
 func (c *Config) Validate() error {
-	if c.WatchDir == "" {
+	if len(c.WatchDirs) == 0 {
 		return errors.New("flag -dir is required")
 	}
+	for _, dir := range c.WatchDirs {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("watch directory %q: %w", dir, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("watch directory %q is not a directory", dir)
+		}
+	}
 	if c.PaperlessURL == "" {
 		return errors.New("flag -url is required")
 	}
-	if c.Token == "" {
-		return errors.New("flag -token is required")
+	u, err := normalizeURL(c.PaperlessURL)
+	if err != nil {
+		return fmt.Errorf("flag -url %q: %w", c.PaperlessURL, err)
+	}
+	c.PaperlessURL = u
+	if strings.ContainsAny(c.APIPath, "?#") {
+		return errors.New("flag -api-path must be a plain path, e.g. /api")
+	}
+	if c.Token != "" && c.TokenFile != "" {
+		return errors.New("flags -token and -token-file are mutually exclusive")
+	}
+	if c.BasicAuth != "" {
+		if _, _, err := c.ParseBasicAuth(); err != nil {
+			return fmt.Errorf("flag -basic-auth: %w", err)
+		}
+		// Both go in the Authorization header, so only one can be sent.
+		if c.Token != "" || c.TokenFile != "" {
+			return errors.New("flag -basic-auth cannot be combined with -token or -token-file: both need the one Authorization header; use a Paperless user for basic auth instead")
+		}
+	} else if c.Token == "" && c.TokenFile == "" {
+		return errors.New("flag -token, -token-file or -basic-auth is required")
+	}
+	if (c.ClientCert == "") != (c.ClientKey == "") {
+		return errors.New("flags -client-cert and -client-key must be set together")
 	}
 	switch c.AfterUpload {
-	case AfterUploadDelete, AfterUploadBackup:
+	case AfterUploadDelete, AfterUploadTrash, AfterUploadBackup, AfterUploadKeep:
 	default:
-		return errors.New("flag -after-upload must be 'delete' or 'backup'")
+		return errors.New("flag -after-upload must be 'delete', 'trash', 'backup' or 'keep'")
+	}
+	if c.AfterUpload == AfterUploadTrash {
+		if err := trash.Available(); err != nil {
+			return fmt.Errorf("flag -after-upload=trash: %w", err)
+		}
+	}
+	if c.PreserveFilename && !c.RenameToUUID {
+		return errors.New("flag -rename-uuid is required when -preserve-filename is set")
+	}
+	if c.AfterUpload == AfterUploadKeep && c.StateFile == "" {
+		return errors.New("flag -state-file is required when -after-upload=keep")
 	}
 	if c.AfterUpload == AfterUploadBackup && c.BackupDir == "" {
 		return errors.New("flag -backup-dir is required when -after-upload=backup")
 	}
+	for _, p := range c.Include {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("flag -include %q: %w", p, err)
+		}
+	}
+	for _, p := range c.Exclude {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("flag -exclude %q: %w", p, err)
+		}
+	}
+	switch c.OnDuplicate {
+	case OnDuplicateSkip, OnDuplicateError, OnDuplicateBackup:
+	default:
+		return errors.New("flag -on-duplicate must be 'skip', 'error' or 'backup'")
+	}
+	if c.OnDuplicate == OnDuplicateBackup && c.BackupDir == "" {
+		return errors.New("flag -backup-dir is required when -on-duplicate=backup")
+	}
+	switch c.BackupSubdirs {
+	case BackupSubdirsNone, BackupSubdirsUploadDate, BackupSubdirsModTime:
+	default:
+		return errors.New("flag -backup-subdirs must be 'none', 'upload-date' or 'mtime'")
+	}
+	switch c.BackupMode {
+	case BackupModeMove, BackupModeCopy:
+	default:
+		return errors.New("flag -backup-mode must be 'move' or 'copy'")
+	}
+	if c.BackupMode == BackupModeCopy && c.StateFile == "" &&
+		(c.AfterUpload == AfterUploadBackup || c.OnDuplicate == OnDuplicateBackup) {
+		return errors.New("flag -state-file is required when -backup-mode=copy")
+	}
+	if c.AfterUpload == AfterUploadBackup || c.OnDuplicate == OnDuplicateBackup {
+		if err := c.checkOverlap("-backup-dir", c.BackupDir); err != nil {
+			return err
+		}
+	}
+	if c.ErrorDir != "" {
+		if err := c.checkOverlap("-error-dir", c.ErrorDir); err != nil {
+			return err
+		}
+	}
+	if _, err := c.ParseTitleTemplate(); err != nil {
+		return fmt.Errorf("flag -title-template: %w", err)
+	}
+	if _, err := transform.Parse(c.TitleTransform); err != nil {
+		return fmt.Errorf("flag -title-transform: %w", err)
+	}
+	if _, err := c.ParseNoteTemplate(); err != nil {
+		return fmt.Errorf("flag -note-template: %w", err)
+	}
+	if c.NoteTemplate != "" && !c.ConfirmConsumption {
+		return errors.New("flag -note-template needs -confirm-consumption, which provides the document id")
+	}
+	if _, err := c.ParseDateRegex(); err != nil {
+		return fmt.Errorf("flag -date-regex: %w", err)
+	}
+	if c.DateRegex != "" && c.DateLayout == "" {
+		return errors.New("flag -date-layout is required when -date-regex is set")
+	}
+	if c.ASNStart < 0 {
+		return errors.New("flag -asn-start must not be negative")
+	}
+	if c.ASNStart > 0 && c.StateFile == "" {
+		return errors.New("flag -state-file is required when -asn-start is set")
+	}
+	if c.OfflineQueue && c.StateFile == "" {
+		return errors.New("flag -state-file is required when -offline-queue is set")
+	}
+	if _, err := c.ParseASNRegex(); err != nil {
+		return fmt.Errorf("flag -asn-regex: %w", err)
+	}
+	if _, err := c.ParseMIMEOverrides(); err != nil {
+		return fmt.Errorf("flag -mime-override: %w", err)
+	}
+	if _, err := c.ParseAllowedMIME(); err != nil {
+		return fmt.Errorf("flag -allowed-mime: %w", err)
+	}
+	for _, e := range c.CustomFields {
+		if _, _, _, err := ParseCustomField(e); err != nil {
+			return fmt.Errorf("flag -custom-field %q: %w", e, err)
+		}
+	}
+	for i, r := range c.Rules {
+		for _, e := range r.CustomFields {
+			if _, _, _, err := ParseCustomField(e); err != nil {
+				return fmt.Errorf("rules[%d]: custom_field %q: %w", i, e, err)
+			}
+		}
+		if r.Dir == "" {
+			return fmt.Errorf("rules[%d]: dir is required", i)
+		}
+		if _, err := filepath.Match(r.Dir, ""); err != nil {
+			return fmt.Errorf("rules[%d]: dir %q: %w", i, r.Dir, err)
+		}
+		if _, ok := c.Targets[r.Target]; r.Target != "" && !ok {
+			return fmt.Errorf("rules[%d]: unknown target %q", i, r.Target)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Targets)) {
+		t := c.Targets[name]
+		if name == "" {
+			return errors.New("targets: name must not be empty")
+		}
+		if t.URL == "" {
+			return fmt.Errorf("targets.%s: url is required", name)
+		}
+		u, err := normalizeURL(t.URL)
+		if err != nil {
+			return fmt.Errorf("targets.%s: url %q: %w", name, t.URL, err)
+		}
+		t.URL = u
+		c.Targets[name] = t
+		if t.Token != "" && t.TokenFile != "" {
+			return fmt.Errorf("targets.%s: token and token_file are mutually exclusive", name)
+		}
+		switch {
+		case c.BasicAuth != "" && (t.Token != "" || t.TokenFile != ""):
+			return fmt.Errorf("targets.%s: token and token_file must not be set with -basic-auth", name)
+		case c.BasicAuth == "" && t.Token == "" && t.TokenFile == "":
+			return fmt.Errorf("targets.%s: token or token_file is required", name)
+		}
+	}
+	if _, err := c.ParseProxy(); err != nil {
+		return fmt.Errorf("flag -proxy: %w", err)
+	}
+	if _, err := c.SlogLevel(); err != nil {
+		return errors.New("flag -log-level must be 'debug', 'info', 'warn' or 'error'")
+	}
+	switch c.LogFormat {
+	case LogFormatJSON, LogFormatText:
+	default:
+		return errors.New("flag -log-format must be 'json' or 'text'")
+	}
+	switch c.LogOutput {
+	case LogOutputStdout, LogOutputStderr:
+	default:
+		return errors.New("flag -log-output must be 'stdout' or 'stderr'")
+	}
+	if c.LogMaxSizeMB < 0 || c.LogMaxBackups < 0 {
+		return errors.New("flags -log-max-size-mb and -log-max-backups must not be negative")
+	}
+	if c.LogCompress && (c.LogFile == "" || c.LogMaxSizeMB == 0 || c.LogMaxBackups == 0) {
+		return errors.New("flag -log-compress needs -log-file, -log-max-size-mb and -log-max-backups")
+	}
+	if c.MaxRetries < 0 {
+		return errors.New("flag -max-retries must not be negative")
+	}
+	if c.MaxRetries > 0 && c.RetryBaseDelay <= 0 {
+		return errors.New("flag -retry-base-delay must be positive when retries are enabled")
+	}
+	if c.CircuitThreshold < 0 {
+		return errors.New("flag -circuit-threshold must not be negative")
+	}
+	if c.CircuitThreshold > 0 && c.CircuitCooldown <= 0 {
+		return errors.New("flag -circuit-cooldown must be positive when -circuit-threshold is set")
+	}
+	if c.ConfirmConsumption && c.ConsumptionTimeout <= 0 {
+		return errors.New("flag -consumption-timeout must be positive when -confirm-consumption is set")
+	}
+	if c.HTTPTimeout < 0 {
+		return errors.New("flag -http-timeout must not be negative")
+	}
+	if c.DialTimeout <= 0 {
+		return errors.New("flag -dial-timeout must be positive")
+	}
+	if c.ResponseHeaderTimeout < 0 {
+		return errors.New("flag -response-header-timeout must not be negative")
+	}
+	if c.Concurrency < 1 {
+		return errors.New("flag -concurrency must be at least 1")
+	}
+	if c.QueueSize < 0 {
+		return errors.New("flag -queue-size must not be negative")
+	}
+	if c.RateLimit < 0 {
+		return errors.New("flag -rate-limit must not be negative")
+	}
+	if c.ShutdownTimeout < 0 {
+		return errors.New("flag -shutdown-timeout must not be negative")
+	}
+	switch c.NotifyOn {
+	case NotifyOnAll, NotifyOnFailure:
+	default:
+		return errors.New("flag -notify-on must be 'all' or 'failure'")
+	}
+	if c.NotifyURL != "" {
+		if u, err := url.Parse(c.NotifyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("flag -notify-url must be an http or https URL")
+		}
+	}
+	if c.HealthAddr != "" && c.HealthInterval <= 0 {
+		return errors.New("flag -health-interval must be positive when -health-addr is set")
+	}
+	switch c.WatchMode {
+	case WatchModeFsnotify, WatchModePoll, WatchModeBoth:
+	default:
+		return errors.New("flag -watch-mode must be 'fsnotify', 'poll' or 'both'")
+	}
+	if c.Debounce <= 0 {
+		return errors.New("flag -debounce must be positive")
+	}
+	if c.StabilityInterval < 0 {
+		return errors.New("flag -stability-interval must not be negative")
+	}
+	if c.MinAge < 0 {
+		return errors.New("flag -min-age must not be negative")
+	}
+	if c.FileWaitTimeout < 0 {
+		return errors.New("flag -file-wait-timeout must not be negative")
+	}
+	if c.WatchMode != WatchModeFsnotify && c.PollInterval <= 0 {
+		return errors.New("flag -poll-interval must be positive when polling is enabled")
+	}
+	if c.CloseWrite && !c.UsesFsnotify() {
+		return errors.New("flag -close-write needs -watch-mode fsnotify or both")
+	}
+	return nil
+}
+
+// ParseTitleTemplate compiles TitleTemplate, returning nil when it is empty.
+func (c *Config) ParseTitleTemplate() (*template.Template, error) {
+	if c.TitleTemplate == "" {
+		return nil, nil
+	}
+	return template.New("title").Parse(c.TitleTemplate)
+}
+
+// ParseNoteTemplate compiles NoteTemplate, returning nil when it is empty.
+func (c *Config) ParseNoteTemplate() (*template.Template, error) {
+	if c.NoteTemplate == "" {
+		return nil, nil
+	}
+	return template.New("note").Parse(c.NoteTemplate)
+}
+
+// ParseDateRegex compiles DateRegex, returning nil when it is empty. The
+// expression must contain at least one capture group.
+func (c *Config) ParseDateRegex() (*regexp.Regexp, error) {
+	if c.DateRegex == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(c.DateRegex)
+	if err != nil {
+		return nil, err
+	}
+	if re.NumSubexp() < 1 {
+		return nil, errors.New("expression needs a capture group around the date")
+	}
+	return re, nil
+}
+
+// DefaultMetadata returns the global metadata set by -tags, -correspondent,
+// -document-type, -storage-path, -custom-field, -owner and the permission
+// flags.
+func (c *Config) DefaultMetadata() Metadata {
+	return Metadata{
+		Tags:          c.Tags,
+		Correspondent: c.Correspondent,
+		DocumentType:  c.DocumentType,
+		StoragePath:   c.StoragePath,
+		CustomFields:  c.CustomFields,
+		Owner:         c.Owner,
+		ViewUsers:     c.ViewUsers,
+		ViewGroups:    c.ViewGroups,
+		EditUsers:     c.EditUsers,
+		EditGroups:    c.EditGroups,
+	}
+}
+
+// RuleFor returns the first rule matching watchDir or fileDir, or nil. Rule
+// directories are made absolute before matching, so both arguments should be
+// absolute paths.
+func (c *Config) RuleFor(watchDir, fileDir string) *Rule {
+	for i := range c.Rules {
+		pattern, err := filepath.Abs(c.Rules[i].Dir)
+		if err != nil {
+			continue
+		}
+		for _, dir := range []string{watchDir, fileDir} {
+			if ok, _ := filepath.Match(pattern, dir); ok {
+				return &c.Rules[i]
+			}
+		}
+	}
 	return nil
 }
 
+// normalizeURL checks that raw is an absolute http or https URL, such as
+// https://paperless.example.com or http://nas:8000/paperless, and returns it
+// without trailing slashes so API paths can be appended.
+func normalizeURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "":
+		return "", errors.New("missing scheme, e.g. https://" + raw)
+	default:
+		return "", errors.New("scheme must be http or https")
+	}
+	if u.Host == "" {
+		return "", errors.New("missing host")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("must not contain a query or fragment")
+	}
+	return strings.TrimRight(u.String(), "/"), nil
+}
+
+// checkOverlap rejects a destination directory, set by flag, that files are
+// moved into while it is also watched: one of the watch directories itself
+// or, with Recursive, a directory below one. Every moved file would come back
+// as new and be uploaded again, forever.
+func (c *Config) checkOverlap(flag, dir string) error {
+	dst := resolvePath(dir)
+	for _, w := range c.WatchDirs {
+		watch := resolvePath(w)
+		switch {
+		case dst == watch:
+			return fmt.Errorf("flag %s must not be a watch directory (%s)", flag, w)
+		case c.Recursive && isWithin(watch, dst):
+			return fmt.Errorf("flag %s must not be inside the watch directory %s with -recursive", flag, w)
+		}
+	}
+	return nil
+}
+
+// resolvePath returns the absolute form of path with symlinks resolved as
+// far as it exists, so two spellings of one directory compare equal, even
+// for a directory that is only created later.
+func resolvePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	// What does not exist yet holds no symlinks; resolve the rest.
+	rest := ""
+	for dir := abs; ; {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(real, rest)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return abs
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
+
+// isWithin reports whether path lies below dir; both must be clean.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ParseBasicAuth splits BasicAuth into user name and password.
+func (c *Config) ParseBasicAuth() (user, password string, err error) {
+	user, password, ok := strings.Cut(c.BasicAuth, ":")
+	if !ok || user == "" {
+		return "", "", errors.New(`must be "user:password"`)
+	}
+	return user, password, nil
+}
+
+// ParseProxy parses Proxy, returning nil when it is empty.
+func (c *Config) ParseProxy() (*url.URL, error) {
+	if c.Proxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(c.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.New("scheme must be http, https or socks5")
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing host")
+	}
+	return u, nil
+}
+
+// ParseMIMEOverrides returns MIMEOverrides as a map from lower-case
+// extension, without leading dot, to MIME type.
+func (c *Config) ParseMIMEOverrides() (map[string]string, error) {
+	types := make(map[string]string, len(c.MIMEOverrides))
+	for _, e := range c.MIMEOverrides {
+		ext, typ, ok := strings.Cut(e, "=")
+		ext = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+		typ = strings.TrimSpace(typ)
+		if !ok || ext == "" || typ == "" {
+			return nil, fmt.Errorf("%q: want ext=type, e.g. heic=image/heic", e)
+		}
+		if _, _, err := mime.ParseMediaType(typ); err != nil {
+			return nil, fmt.Errorf("%q: %w", e, err)
+		}
+		types[ext] = typ
+	}
+	return types, nil
+}
+
+// ParseAllowedMIME returns AllowedMIME as lower-case media types without
+// parameters. A type may end in "/*" to allow a whole family, as in
+// "image/*".
+func (c *Config) ParseAllowedMIME() ([]string, error) {
+	types := make([]string, 0, len(c.AllowedMIME))
+	for _, e := range c.AllowedMIME {
+		typ := strings.ToLower(strings.TrimSpace(e))
+		major, minor, ok := strings.Cut(typ, "/")
+		if !ok || major == "" || major == "*" || minor == "" {
+			return nil, fmt.Errorf("%q: want a type such as application/pdf or image/*", e)
+		}
+		if minor != "*" {
+			mt, _, err := mime.ParseMediaType(typ)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", e, err)
+			}
+			typ = mt
+		}
+		types = append(types, typ)
+	}
+	return types, nil
+}
+
+// ParseASNRegex compiles ASNRegex, returning nil when it is empty. The
+// expression must contain exactly one capture group.
+func (c *Config) ParseASNRegex() (*regexp.Regexp, error) {
+	if c.ASNRegex == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(c.ASNRegex)
+	if err != nil {
+		return nil, err
+	}
+	if re.NumSubexp() != 1 {
+		return nil, errors.New("expression needs exactly one capture group around the number")
+	}
+	return re, nil
+}
+
+// SlogLevel parses LogLevel.
+func (c *Config) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(c.LogLevel))
+	return level, err
+}
+
+// LoadTokenFile reads Token from TokenFile, trimming trailing whitespace and
+// newlines, and does the same for every target. It does nothing for tokens
+// without a file.
+func (c *Config) LoadTokenFile() error {
+	if c.TokenFile != "" {
+		token, err := readToken(c.TokenFile)
+		if err != nil {
+			return err
+		}
+		c.Token = token
+	}
+	for name, t := range c.Targets {
+		if t.TokenFile == "" {
+			continue
+		}
+		token, err := readToken(t.TokenFile)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", name, err)
+		}
+		t.Token = token
+		c.Targets[name] = t
+	}
+	return nil
+}
+
+// readToken reads an API token from path.
+func readToken(path string) (string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read token file: %w", err)
+	}
+	token := strings.TrimRightFunc(string(raw), unicode.IsSpace)
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// UsesFsnotify reports whether native filesystem events should be used.
+func (c *Config) UsesFsnotify() bool {
+	return c.WatchMode == WatchModeFsnotify || c.WatchMode == WatchModeBoth
+}
+
+// UsesPolling reports whether the periodic directory scan should be used.
+func (c *Config) UsesPolling() bool {
+	return c.WatchMode == WatchModePoll || c.WatchMode == WatchModeBoth
+}
+
 // ParseExtensions converts a comma-separated extension string (e.g. "pdf,png,jpg")
 // into a normalised set: lowercase, no leading dot.
-func ParseExtensions(raw string) map[string]struct{} {
-	result := make(map[string]struct{})
+func ParseExtensions(raw string) Extensions {
+	result := make(Extensions)
 	if raw == "" {
 		return result
 	}
@@ -71,3 +1089,15 @@ func ParseExtensions(raw string) map[string]struct{} {
 	}
 	return result
 }
+
+// ParseList splits a comma-separated string into its trimmed, non-empty
+// elements, preserving order.
+func ParseList(raw string) []string {
+	var result []string
+	for _, e := range strings.Split(raw, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			result = append(result, e)
+		}
+	}
+	return result
+}
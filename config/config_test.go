@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns a configuration that passes Validate, watching a new
+// temp directory, like the flag defaults with -dir, -url and -token set.
+func validConfig(t *testing.T) *Config {
+	t.Helper()
+	return &Config{
+		WatchDirs:          []string{t.TempDir()},
+		PaperlessURL:       "http://paperless.example.com",
+		Token:              "token",
+		APIPath:            "/api",
+		AfterUpload:        AfterUploadDelete,
+		OnDuplicate:        OnDuplicateSkip,
+		BackupSubdirs:      BackupSubdirsNone,
+		BackupMode:         BackupModeMove,
+		WatchMode:          WatchModeBoth,
+		Concurrency:        1,
+		Debounce:           2 * time.Second,
+		PollInterval:       30 * time.Second,
+		StabilityInterval:  time.Second,
+		FileWaitTimeout:    30 * time.Second,
+		QueueSize:          100,
+		HTTPTimeout:        2 * time.Minute,
+		DialTimeout:        30 * time.Second,
+		ShutdownTimeout:    30 * time.Second,
+		ConsumptionTimeout: 5 * time.Minute,
+		RetryBaseDelay:     time.Second,
+		CircuitCooldown:    time.Minute,
+		NotifyOn:           NotifyOnAll,
+		LogLevel:           "info",
+		LogFormat:          LogFormatText,
+		LogOutput:          LogOutputStdout,
+	}
+}
+
+func TestValidateValidConfig(t *testing.T) {
+	if err := validConfig(t).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestValidateWatchedDestinations covers backup and error directories that
+// are, lie in or contain a watch directory: only those files would be moved
+// back into a watched place are rejected.
+func TestValidateWatchedDestinations(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		dest      func(watch string) string // the backup or error dir
+		recursive bool
+		wantErr   string // substring; empty for no error
+	}{
+		{"identical", func(w string) string { return w }, false, "must not be a watch directory"},
+		{"identical with trailing slash", func(w string) string { return w + string(filepath.Separator) }, false, "must not be a watch directory"},
+		{"identical via dot-dot", func(w string) string { return filepath.Join(w, "sub", "..") }, false, "must not be a watch directory"},
+		{"identical via symlink", func(w string) string { return symlinkTo(t, w) }, false, "must not be a watch directory"},
+		{"nested, not recursive", func(w string) string { return filepath.Join(w, "done") }, false, ""},
+		{"nested, recursive", func(w string) string { return filepath.Join(w, "done") }, true, "must not be inside the watch directory"},
+		{"deeply nested, recursive", func(w string) string { return filepath.Join(w, "a", "b", "done") }, true, "must not be inside the watch directory"},
+		{"nested via symlink, recursive", func(w string) string { return filepath.Join(symlinkTo(t, w), "done") }, true, "must not be inside the watch directory"},
+		{"parent of the watch directory", func(w string) string { return filepath.Dir(w) }, true, ""},
+		{"sibling with common prefix", func(w string) string { return w + "-done" }, true, ""},
+	} {
+		for _, dir := range []struct {
+			flag string
+			set  func(c *Config, dir string)
+		}{
+			{"-backup-dir", func(c *Config, dir string) {
+				c.AfterUpload, c.BackupDir = AfterUploadBackup, dir
+			}},
+			{"-error-dir", func(c *Config, dir string) { c.ErrorDir = dir }},
+		} {
+			t.Run(tt.name+" "+dir.flag, func(t *testing.T) {
+				c := validConfig(t)
+				c.Recursive = tt.recursive
+				dir.set(c, tt.dest(c.WatchDirs[0]))
+				err := c.Validate()
+				switch {
+				case tt.wantErr == "" && err != nil:
+					t.Errorf("unexpected error: %v", err)
+				case tt.wantErr != "" && err == nil:
+					t.Errorf("no error, want one containing %q", tt.wantErr)
+				case tt.wantErr != "" && !strings.Contains(err.Error(), dir.flag+" "+tt.wantErr):
+					t.Errorf("error %q, want one containing %q", err, dir.flag+" "+tt.wantErr)
+				}
+			})
+		}
+	}
+}
+
+// TestValidateWatchedDestinationsOfEveryDir checks every watch directory, not
+// only the first.
+func TestValidateWatchedDestinationsOfEveryDir(t *testing.T) {
+	c := validConfig(t)
+	second := t.TempDir()
+	c.WatchDirs = append(c.WatchDirs, second)
+	c.ErrorDir = second
+	if err := c.Validate(); err == nil {
+		t.Error("error dir equal to the second watch directory accepted")
+	}
+}
+
+// symlinkTo returns a new symlink to dir, skipping the test where symlinks
+// cannot be created.
+func symlinkTo(t *testing.T, dir string) string {
+	t.Helper()
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+	return link
+}
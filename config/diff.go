@@ -0,0 +1,46 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Changed returns the config keys whose values differ between a and b, in
+// field order.
+func Changed(a, b *Config) []string {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	t := va.Type()
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// CopyKeys sets the fields of dst named by the config keys in keys to their
+// values in src. It panics on a key that names no field, so a list of keys
+// cannot silently go stale.
+func CopyKeys(dst, src *Config, keys []string) {
+	vd, vs := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src).Elem()
+	t := vd.Type()
+	fields := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if key != "" && key != "-" {
+			fields[key] = i
+		}
+	}
+	for _, key := range keys {
+		i, ok := fields[key]
+		if !ok {
+			panic("config: no field for key " + key)
+		}
+		vd.Field(i).Set(vs.Field(i))
+	}
+}
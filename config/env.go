@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to the upper-cased config key to form the name of
+// the environment variable for an option, e.g. PAPERLESSLINK_TOKEN.
+const EnvPrefix = "PAPERLESSLINK_"
+
+// EnvName returns the environment variable that sets the option with the
+// given config file key.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(key)
+}
+
+// FromEnv overrides fields of c with any PAPERLESSLINK_* environment
+// variables that are set. Variable names derive from the yaml keys; list
+// values are comma-separated.
+func FromEnv(c *Config) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		name := EnvName(key)
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(v.Field(i), raw); err != nil {
+			return fmt.Errorf("environment variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setFromString assigns raw to field. Strings are taken verbatim, string
+// slices are split on commas, and everything else is decoded as a YAML
+// scalar so it accepts the same syntax as the config file.
+func setFromString(field reflect.Value, raw string) error {
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(raw)
+		return nil
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		field.Set(reflect.ValueOf(ParseList(raw)).Convert(field.Type()))
+		return nil
+	}
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: raw}
+	return node.Decode(field.Addr().Interface())
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadFile reads the YAML file at path into c. Keys that are absent leave the
+// corresponding fields untouched, so callers can pre-fill defaults. Unknown
+// keys are rejected to catch typos early.
+func LoadFile(path string, c *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// UnmarshalYAML accepts either a list (`[pdf, png]`) or a comma-separated
+// string (`pdf,png`) and normalises it like ParseExtensions.
+func (e *Extensions) UnmarshalYAML(node *yaml.Node) error {
+	var list []string
+	if node.Kind == yaml.SequenceNode {
+		if err := node.Decode(&list); err != nil {
+			return err
+		}
+	} else {
+		var raw string
+		if err := node.Decode(&raw); err != nil {
+			return err
+		}
+		list = ParseList(raw)
+	}
+	*e = make(Extensions)
+	for _, ext := range list {
+		for k := range ParseExtensions(ext) {
+			(*e)[k] = struct{}{}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"log/slog"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// redactedValue replaces secrets in Redacted.
+const redactedValue = "REDACTED"
+
+// Redacted returns a copy of c that is safe to log: the tokens and the basic
+// auth password are masked, as are the passwords and query parameter values
+// of every URL. Everything else, including the slices and maps that hold no
+// secrets, is shared with c.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.Token = mask(c.Token)
+	if user, _, ok := strings.Cut(c.BasicAuth, ":"); ok {
+		r.BasicAuth = user + ":" + redactedValue
+	}
+	r.PaperlessURL = redactURL(c.PaperlessURL)
+	r.Proxy = redactURL(c.Proxy)
+	r.NotifyURL = redactURL(c.NotifyURL)
+	if c.Targets != nil {
+		r.Targets = make(map[string]Target, len(c.Targets))
+		for name, t := range c.Targets {
+			t.URL = redactURL(t.URL)
+			t.Token = mask(t.Token)
+			r.Targets[name] = t
+		}
+	}
+	return &r
+}
+
+// LogValue implements slog.LogValuer, logging the Redacted config as a group
+// keyed like the config file, so a Config can be passed to slog as it is.
+func (c *Config) LogValue() slog.Value {
+	v := reflect.ValueOf(c.Redacted()).Elem()
+	t := v.Type()
+	attrs := make([]slog.Attr, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		switch f := v.Field(i).Interface().(type) {
+		case time.Duration:
+			attrs = append(attrs, slog.String(key, f.String()))
+		case Extensions:
+			attrs = append(attrs, slog.String(key, f.String()))
+		default:
+			attrs = append(attrs, slog.Any(key, f))
+		}
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// mask returns redactedValue for a non-empty s.
+func mask(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// redactURL masks the password and the query parameter values of s, which
+// may carry tokens, as webhook URLs often do. A URL that does not parse is
+// masked entirely.
+func redactURL(s string) string {
+	if s == "" {
+		return ""
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return redactedValue
+	}
+	if q := u.Query(); len(q) > 0 {
+		for k := range q {
+			q.Set(k, redactedValue)
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"paperlesslink/config"
+)
+
+// options holds command-line settings that are not part of config.Config.
+type options struct {
+	configFile  string
+	showVersion bool
+}
+
+// newFlagSet defines every command-line flag. Config options are bound
+// directly to the fields of cfg, so defining the flags also applies their
+// defaults to cfg.
+func newFlagSet(cfg *config.Config, opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet("paperlesslink", flag.ExitOnError)
+
+	fs.StringVar(&opts.configFile, "config", "", "YAML config file; explicit flags override its values")
+	fs.BoolVar(&opts.showVersion, "version", false, "Print version and exit")
+
+	fs.Var(&listFlag{dst: &cfg.WatchDirs}, "dir", "Directory to watch for new files; repeat or comma-separate for several (required)")
+	fs.StringVar(&cfg.PaperlessURL, "url", "", "Paperless-ngx base URL, e.g. https://paperless.example.com (required)")
+	fs.StringVar(&cfg.Token, "token", "", "Paperless-ngx API token (required unless -token-file is set)")
+	fs.StringVar(&cfg.APIPath, "api-path", "/api", "Path of the Paperless API below -url")
+	fs.StringVar(&cfg.TokenFile, "token-file", "", "Read the API token from this file, e.g. /run/secrets/paperless_token")
+	fs.StringVar(&cfg.BasicAuth, "basic-auth", "", `Authenticate with HTTP basic auth as "user:password" instead of an API token; not combinable with -token, as both need the Authorization header`)
+	fs.Var(extFlag{dst: &cfg.AllowedExts}, "ext", "Comma-separated allowed file extensions, e.g. pdf,png (empty = all)")
+	fs.Var(&listFlag{dst: &cfg.Include}, "include", `Comma-separated file name globs to upload, e.g. "scan_*" (empty = all)`)
+	fs.Var(&listFlag{dst: &cfg.Exclude}, "exclude", `Comma-separated file name globs to ignore, e.g. "*.tmp,~$*"; wins over -include`)
+	fs.BoolVar(&cfg.NoIgnoreTemp, "no-ignore-temp", false, "Also upload hidden files and temp files (.part, .tmp, .crdownload, ~)")
+	fs.StringVar(&cfg.TitleTemplate, "title-template", "", `Go template for the title, e.g. "{{.Dir}} - {{.ModTime.Format \"2006-01\"}} - {{.Stem}}"`)
+	fs.Var(&listFlag{dst: &cfg.TitleTransform}, "title-transform", `Comma-separated steps to clean up the file name stem for the title, e.g. "strip-prefix:SCAN_,replace:_=space,titlecase"`)
+	fs.StringVar(&cfg.NoteTemplate, "note-template", "", `Go template for a note added to each consumed document, e.g. "Imported from {{.Dir}}/{{.File}}" (needs -confirm-consumption)`)
+	fs.StringVar(&cfg.DateRegex, "date-regex", "", `Regex on the file name whose first group is the created date, e.g. "^(\d{4}-\d{2}-\d{2})"`)
+	fs.StringVar(&cfg.DateLayout, "date-layout", "", `Go time layout for the -date-regex match, e.g. "2006-01-02"`)
+	fs.BoolVar(&cfg.UseMTime, "use-mtime", false, "Send the file modification time as the created date (-date-regex wins if it matches)")
+	fs.Var(&listFlag{dst: &cfg.Tags}, "tags", "Comma-separated tag IDs or names to attach to every document")
+	fs.StringVar(&cfg.ConsumptionTag, "consumption-tag", "", "Tag ID or name added to every document, even where a rule sets other tags, to trigger Paperless workflows")
+	fs.StringVar(&cfg.Correspondent, "correspondent", "", "Correspondent ID or name to assign")
+	fs.StringVar(&cfg.DocumentType, "document-type", "", "Document type ID or name to assign")
+	fs.StringVar(&cfg.StoragePath, "storage-path", "", "Storage path ID or name to assign")
+	fs.Var(&repeatFlag{dst: &cfg.CustomFields}, "custom-field", `Custom field to set, as "name=value" or "id=value"; repeat for several`)
+	fs.StringVar(&cfg.Owner, "owner", "", "User ID or username to own uploaded documents (default: the token's user)")
+	fs.Var(&listFlag{dst: &cfg.ViewUsers}, "view-users", "Comma-separated user IDs or usernames allowed to view uploaded documents")
+	fs.Var(&listFlag{dst: &cfg.ViewGroups}, "view-groups", "Comma-separated group IDs or names allowed to view uploaded documents")
+	fs.Var(&listFlag{dst: &cfg.EditUsers}, "edit-users", "Comma-separated user IDs or usernames allowed to edit uploaded documents")
+	fs.Var(&listFlag{dst: &cfg.EditGroups}, "edit-groups", "Comma-separated group IDs or names allowed to edit uploaded documents")
+	fs.IntVar(&cfg.ASNStart, "asn-start", 0, "Assign sequential archive serial numbers starting here, counted in -state-file (0 = off)")
+	fs.StringVar(&cfg.ASNRegex, "asn-regex", "", `Regex on the file name whose single group is the archive serial number, e.g. "ASN(\d+)"`)
+	fs.BoolVar(&cfg.CreateMissingMetadata, "create-missing-metadata", false, "Create tags/correspondents/document types that don't exist yet")
+	fs.Var(&listFlag{dst: &cfg.MIMEOverrides}, "mime-override", "Content type to send for an extension as ext=type, e.g. heic=image/heic; comma-separated or repeated")
+	fs.BoolVar(&cfg.BufferUpload, "buffer-upload", false, "Build each upload in memory and send it with a Content-Length instead of chunked")
+	fs.BoolVar(&cfg.GzipUpload, "gzip-upload", false, "Gzip uploads of compressible formats such as text and TIFF; the proxy in front of Paperless must decompress them")
+	fs.BoolVar(&cfg.VerifyMIME, "verify-mime", false, "Reject files whose content does not match their extension, e.g. HTML saved as .pdf")
+	fs.Var(&listFlag{dst: &cfg.AllowedMIME}, "allowed-mime", `Comma-separated content types files must have when sniffed, e.g. "application/pdf,image/*" (empty = all)`)
+	fs.BoolVar(&cfg.DryRun, "dry-run", false, "Log what would be uploaded and done with each file, without doing it")
+	fs.BoolVar(&cfg.RenameToUUID, "rename-uuid", false, "Rename file to UUID before upload (original name used as title)")
+	fs.BoolVar(&cfg.PreserveFilename, "preserve-filename", false, "With -rename-uuid, still send the original file name to Paperless")
+	fs.StringVar((*string)(&cfg.AfterUpload), "after-upload", string(config.AfterUploadDelete), "Action after upload: delete | trash | backup | keep (keep needs -state-file)")
+	fs.StringVar(&cfg.BackupDir, "backup-dir", "", "Backup directory (required when -after-upload=backup)")
+	fs.StringVar((*string)(&cfg.BackupMode), "backup-mode", string(config.BackupModeMove), "Back up by: move | copy (copy leaves the original in place, needs -state-file)")
+	fs.StringVar((*string)(&cfg.BackupSubdirs), "backup-subdirs", string(config.BackupSubdirsNone), "Sort backups into YYYY/MM subdirectories: none | upload-date | mtime")
+	fs.StringVar(&cfg.StateFile, "state-file", "", "JSON file recording checksums of uploaded files to prevent duplicates")
+	fs.StringVar(&cfg.Manifest, "manifest", "", "Append a JSON line per processed file (path, checksum, document, action) to this file")
+	fs.BoolVar(&cfg.OfflineQueue, "offline-queue", false, "Queue files in -state-file while Paperless is unreachable and upload them once it is back")
+	fs.BoolVar(&cfg.SkipExisting, "skip-existing", false, "Skip files whose content is already in Paperless (checked by checksum), running only the post-upload action")
+	fs.StringVar((*string)(&cfg.OnDuplicate), "on-duplicate", string(config.OnDuplicateError), "Files Paperless rejects as duplicates: skip | error | backup")
+	fs.StringVar(&cfg.ErrorDir, "error-dir", "", "Move files that fail to upload here, with a .error.txt sidecar")
+	fs.IntVar(&cfg.MaxRetries, "max-retries", 5, "Retries for network errors and HTTP 5xx/429 (0 = no retry)")
+	fs.DurationVar(&cfg.RetryBaseDelay, "retry-base-delay", 2*time.Second, "Initial retry backoff, doubled on each attempt")
+	fs.IntVar(&cfg.CircuitThreshold, "circuit-threshold", 3, "Uploads in a row that may find Paperless unreachable before the next ones fail at once (0 = never)")
+	fs.DurationVar(&cfg.CircuitCooldown, "circuit-cooldown", time.Minute, "How long uploads fail at once before Paperless is probed again")
+	fs.BoolVar(&cfg.ConfirmConsumption, "confirm-consumption", false, "Wait for Paperless to consume the document before delete/backup")
+	fs.DurationVar(&cfg.ConsumptionTimeout, "consumption-timeout", 10*time.Minute, "Maximum time to wait for consumption with -confirm-consumption")
+	fs.IntVar(&cfg.Concurrency, "concurrency", 1, "Number of parallel uploads")
+	fs.IntVar(&cfg.QueueSize, "queue-size", 16, "Detected files each watcher queues ahead of the uploads before holding them back")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", 0, "Maximum uploads per minute across all workers (0 = unlimited)")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "Time allowed to finish in-flight and queued uploads on shutdown before they are cancelled")
+	fs.DurationVar(&cfg.HTTPTimeout, "http-timeout", 120*time.Second, "Timeout for each request to Paperless, including the upload (0 = none)")
+	fs.DurationVar(&cfg.DialTimeout, "dial-timeout", 30*time.Second, "Timeout for connecting to Paperless or the proxy")
+	fs.DurationVar(&cfg.ResponseHeaderTimeout, "response-header-timeout", 0, "Timeout for Paperless to answer once a request is sent, not counting the upload (0 = none)")
+	fs.StringVar(&cfg.Proxy, "proxy", "", "Proxy URL for requests to Paperless, e.g. http://proxy:3128 (default: HTTP(S)_PROXY env)")
+	fs.StringVar(&cfg.UserAgent, "user-agent", "paperlesslink/"+version, "User-Agent header sent to Paperless, e.g. curl/7.81.0 for proxies that filter it")
+	fs.StringVar(&cfg.CACert, "ca-cert", "", "PEM file with an additional root CA to trust for Paperless")
+	fs.BoolVar(&cfg.InsecureSkipVerify, "insecure-skip-verify", false, "Disable TLS certificate verification (insecure)")
+	fs.StringVar(&cfg.ClientCert, "client-cert", "", "PEM client certificate for mutual TLS (needs -client-key)")
+	fs.StringVar(&cfg.ClientKey, "client-key", "", "PEM private key of -client-cert")
+	fs.StringVar(&cfg.NotifyURL, "notify-url", "", "Webhook URL receiving a JSON POST {file, title, status, error} after each upload")
+	fs.StringVar((*string)(&cfg.NotifyOn), "notify-on", string(config.NotifyOnAll), "Uploads reported to -notify-url: all | failure")
+	fs.BoolVar(&cfg.SkipStartupCheck, "skip-startup-check", false, "Start even if Paperless is unreachable or rejects the token")
+	fs.StringVar(&cfg.HealthAddr, "health-addr", "", "Serve /healthz and /readyz on this address, e.g. :8080 (empty = off)")
+	fs.DurationVar(&cfg.HealthInterval, "health-interval", 30*time.Second, "How often /readyz checks that Paperless is reachable")
+	fs.StringVar(&cfg.PprofAddr, "pprof-addr", "", "Serve net/http/pprof under /debug/pprof/ on this address, e.g. localhost:6060 (empty = off)")
+	fs.StringVar(&cfg.LogFile, "log-file", "", "Path to log file (default: console only)")
+	fs.IntVar(&cfg.LogMaxSizeMB, "log-max-size-mb", 0, "Rotate the log file when it reaches this size in MB (0 = never)")
+	fs.IntVar(&cfg.LogMaxBackups, "log-max-backups", 5, "Number of rotated log files to keep")
+	fs.BoolVar(&cfg.LogCompress, "log-compress", false, "Gzip rotated log files in the background")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "Minimum log level: debug | info | warn | error")
+	fs.StringVar((*string)(&cfg.LogFormat), "log-format", string(config.LogFormatJSON), "Log output format: json | text")
+	fs.StringVar((*string)(&cfg.LogOutput), "log-output", string(config.LogOutputStdout), "Console stream for logs: stdout | stderr")
+	fs.BoolVar(&cfg.LogQuiet, "quiet", false, "Only log warnings and errors to the console; -log-file still gets every level")
+	fs.DurationVar(&cfg.Debounce, "debounce", 750*time.Millisecond, "Wait this long after the last event for a file before handling it")
+	fs.StringVar((*string)(&cfg.WatchMode), "watch-mode", string(config.WatchModeBoth), "File detection: fsnotify | poll | both")
+	fs.DurationVar(&cfg.PollInterval, "poll-interval", 5*time.Second, "Fallback poll interval for fsnotify")
+	fs.BoolVar(&cfg.CloseWrite, "close-write", false, "Linux: handle a file as soon as its writer closes it instead of after -debounce")
+	fs.DurationVar(&cfg.StabilityInterval, "stability-interval", time.Second, "File size must be unchanged for this long before upload (0 = off)")
+	fs.DurationVar(&cfg.MinAge, "min-age", 0, "File must not have been modified for this long before upload (0 = off)")
+	fs.DurationVar(&cfg.FileWaitTimeout, "file-wait-timeout", 2*time.Second, "How long to retry a file that cannot be accessed yet, e.g. on slow storage, before skipping it")
+	fs.BoolVar(&cfg.Recursive, "recursive", false, "Also watch subdirectories")
+	fs.BoolVar(&cfg.FollowSymlinks, "follow-symlinks", false, "Upload symlinked files and, with -recursive, watch symlinked directories")
+	fs.BoolVar(&cfg.ProcessExisting, "process-existing", true, "Upload files already in the directory at startup")
+	fs.BoolVar(&cfg.Once, "once", false, "Upload the files currently in the directories, then exit (non-zero if any upload failed)")
+
+	return fs
+}
+
+// parseConfig builds the effective configuration from the command line and
+// environment. Precedence is flags > env > config file > defaults, except for
+// the API token, where PAPERLESSLINK_TOKEN wins over -token so the secret
+// can be kept out of the process list.
+//
+// The flags are parsed once to find -config, then again on top of a config
+// pre-filled with the defaults, the file's values and the environment, so
+// only explicitly given flags override them.
+func parseConfig(args []string) (*config.Config, options, *flag.FlagSet, error) {
+	var opts options
+	fs := newFlagSet(&config.Config{}, &opts)
+	_ = fs.Parse(args) // ExitOnError
+	configFile := opts.configFile
+	if configFile == "" {
+		configFile = os.Getenv(config.EnvName("config"))
+	}
+
+	cfg := &config.Config{}
+	fs = newFlagSet(cfg, &opts)
+	if configFile != "" {
+		if err := config.LoadFile(configFile, cfg); err != nil {
+			return nil, opts, fs, err
+		}
+	}
+	if err := config.FromEnv(cfg); err != nil {
+		return nil, opts, fs, err
+	}
+	_ = fs.Parse(args)
+	opts.configFile = configFile
+
+	if token, ok := os.LookupEnv(config.EnvName("token")); ok {
+		cfg.Token = token
+	}
+	return cfg, opts, fs, nil
+}
+
+// listFlag is a flag.Value collecting repeated and/or comma-separated values.
+// The first use on the command line replaces any value from the config file.
+type listFlag struct {
+	dst *[]string
+	set bool
+}
+
+func (l *listFlag) String() string {
+	if l.dst == nil {
+		return ""
+	}
+	return strings.Join(*l.dst, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	if !l.set {
+		*l.dst = nil
+		l.set = true
+	}
+	*l.dst = append(*l.dst, config.ParseList(v)...)
+	return nil
+}
+
+// repeatFlag is a flag.Value collecting repeated values, for values that may
+// themselves contain commas. Like listFlag, the first use replaces any value
+// from the config file.
+type repeatFlag struct {
+	dst *[]string
+	set bool
+}
+
+func (r *repeatFlag) String() string {
+	if r.dst == nil {
+		return ""
+	}
+	return strings.Join(*r.dst, " ")
+}
+
+func (r *repeatFlag) Set(v string) error {
+	if !r.set {
+		*r.dst = nil
+		r.set = true
+	}
+	*r.dst = append(*r.dst, v)
+	return nil
+}
+
+// extFlag is a flag.Value parsing a comma-separated extension list.
+type extFlag struct {
+	dst *config.Extensions
+}
+
+func (e extFlag) String() string {
+	if e.dst == nil {
+		return ""
+	}
+	return e.dst.String()
+}
+
+func (e extFlag) Set(v string) error {
+	*e.dst = config.ParseExtensions(v)
+	return nil
+}
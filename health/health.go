@@ -0,0 +1,139 @@
+// Package health serves liveness and readiness probes over HTTP for
+// supervisors such as Kubernetes. /healthz answers as long as the process
+// runs; /readyz only succeeds while the watchers are running and the last
+// periodic check against Paperless passed.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Options configures the probe server.
+type Options struct {
+	// Addr is the listen address, e.g. ":8080".
+	Addr string
+
+	// Check is called every Interval; readiness reflects its last result.
+	Check    func(ctx context.Context) error
+	Interval time.Duration
+}
+
+// Server is a running probe server; create one with Start.
+type Server struct {
+	srv  *http.Server
+	stop chan struct{}
+	done chan struct{}
+
+	mu       sync.Mutex
+	watching bool
+	checkErr error // nil once a check passed
+}
+
+// Start listens on opts.Addr and begins checking Paperless in the background.
+func Start(opts Options) (*Server, error) {
+	ln, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("health listener: %w", err)
+	}
+
+	s := &Server{
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		checkErr: errors.New("paperless not checked yet"),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", s.handleReady)
+	s.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("health server failed", "error", err)
+		}
+	}()
+	go s.checkLoop(opts)
+
+	slog.Info("health endpoint listening", "addr", ln.Addr().String())
+	return s, nil
+}
+
+// SetWatching records whether the watchers are running.
+func (s *Server) SetWatching(watching bool) {
+	s.mu.Lock()
+	s.watching = watching
+	s.mu.Unlock()
+}
+
+// Close stops the background check and shuts the server down.
+func (s *Server) Close() error {
+	close(s.stop)
+	<-s.done
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.srv.Shutdown(ctx)
+}
+
+// handleReady answers 200 when ready and 503 with the reason otherwise.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	watching, checkErr := s.watching, s.checkErr
+	s.mu.Unlock()
+
+	switch {
+	case !watching:
+		http.Error(w, "not watching", http.StatusServiceUnavailable)
+	case checkErr != nil:
+		http.Error(w, "paperless: "+checkErr.Error(), http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// checkLoop runs opts.Check right away and then every opts.Interval until
+// Close is called.
+func (s *Server) checkLoop(opts Options) {
+	defer close(s.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		err := opts.Check(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		s.mu.Lock()
+		changed := first || (err == nil) != (s.checkErr == nil)
+		s.checkErr = err
+		s.mu.Unlock()
+
+		// Only log transitions so a healthy instance stays quiet.
+		switch {
+		case changed && err != nil:
+			slog.Warn("paperless health check failed", "error", err)
+		case changed:
+			slog.Info("paperless health check passed")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+	}
+}
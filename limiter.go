@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiter is a token bucket holding a single token, shared by all upload
+// workers so -rate-limit applies globally. A nil limiter never waits.
+type limiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time // when the next token becomes available
+}
+
+// newLimiter returns a limiter allowing perMinute uploads per minute, or nil
+// when perMinute is zero.
+func newLimiter(perMinute float64) *limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &limiter{interval: time.Duration(float64(time.Minute) / perMinute)}
+}
+
+// reserve takes the next token and returns how long the caller must wait
+// before using it.
+func (l *limiter) reserve() time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	return delay
+}
+
+// sleep waits for d or until ctx is cancelled.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
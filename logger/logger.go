@@ -1,31 +1,75 @@
-// Package logger initialises a structured slog logger that writes to stdout
-// and, optionally, to an additional log file simultaneously.
+// Package logger initialises a structured slog logger that writes to the
+// console (stdout or stderr) and, optionally, to an additional size-rotated
+// log file simultaneously.
 package logger
 
 import (
+	"context"
+	"errors"
 	"io"
 	"log/slog"
 	"os"
 )
 
-// Init sets the default slog logger. When logFile is empty only stdout is used.
-// Returns a cleanup function that closes the log file (if any).
-func Init(logFile string) (cleanup func(), err error) {
-	writers := []io.Writer{os.Stdout}
+// Options configures Init.
+type Options struct {
+	// File is an additional log file; empty means the console only.
+	File string
 
-	var f *os.File
-	if logFile != "" {
-		f, err = os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	// Console receives the console output; nil means os.Stdout.
+	Console io.Writer
+
+	// Quiet only writes warnings and errors to the console; File still gets
+	// everything from Level up.
+	Quiet bool
+
+	// Level is the minimum level that is logged; pass a *slog.LevelVar to
+	// change it at runtime.
+	Level slog.Leveler
+
+	// MaxSizeMB rotates File once it would grow beyond this many megabytes;
+	// 0 disables rotation. MaxBackups is the number of rotated files kept
+	// (File.1 is the newest); with 0 the file is simply truncated.
+	MaxSizeMB  int
+	MaxBackups int
+
+	// Compress gzips rotated files in the background; the active file stays
+	// plain text.
+	Compress bool
+
+	// Text selects the human-friendly key=value handler instead of JSON.
+	Text bool
+}
+
+// Init sets the default slog logger. When opts.File is empty only the
+// console is used. Returns a cleanup function that closes the log file (if
+// any).
+func Init(opts Options) (cleanup func(), err error) {
+	console := opts.Console
+	if console == nil {
+		console = os.Stdout
+	}
+
+	var f *rotatingFile
+	if opts.File != "" {
+		f, err = openRotatingFile(opts.File, int64(opts.MaxSizeMB)<<20, opts.MaxBackups, opts.Compress)
 		if err != nil {
 			return nil, err
 		}
-		writers = append(writers, f)
 	}
 
-	mw := io.MultiWriter(writers...)
-	handler := slog.NewJSONHandler(mw, &slog.HandlerOptions{
-		Level: slog.LevelDebug,
-	})
+	var handler slog.Handler
+	switch {
+	case f == nil:
+		handler = newHandler(console, opts.quietLevel(), opts.Text)
+	case opts.Quiet:
+		handler = fanout{
+			newHandler(console, opts.quietLevel(), opts.Text),
+			newHandler(f, opts.Level, opts.Text),
+		}
+	default:
+		handler = newHandler(io.MultiWriter(console, f), opts.Level, opts.Text)
+	}
 	slog.SetDefault(slog.New(handler))
 
 	cleanup = func() {
@@ -35,3 +79,72 @@ func Init(logFile string) (cleanup func(), err error) {
 	}
 	return cleanup, nil
 }
+
+func newHandler(w io.Writer, level slog.Leveler, text bool) slog.Handler {
+	handlerOpts := &slog.HandlerOptions{Level: level}
+	if text {
+		return slog.NewTextHandler(w, handlerOpts)
+	}
+	return slog.NewJSONHandler(w, handlerOpts)
+}
+
+// quietLevel returns the console level: opts.Level, raised to warnings with
+// opts.Quiet.
+func (opts Options) quietLevel() slog.Leveler {
+	if !opts.Quiet {
+		return opts.Level
+	}
+	return atLeast{opts.Level, slog.LevelWarn}
+}
+
+// atLeast is a Leveler that follows base but never drops below min, so a
+// level changed at runtime still applies.
+type atLeast struct {
+	base slog.Leveler
+	min  slog.Level
+}
+
+func (l atLeast) Level() slog.Level {
+	if l.base == nil {
+		return l.min
+	}
+	return max(l.base.Level(), l.min)
+}
+
+// fanout passes each record to every handler that wants its level.
+type fanout []slog.Handler
+
+func (h fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, hh := range h {
+		if hh.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanout) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, hh := range h {
+		if hh.Enabled(ctx, r.Level) {
+			errs = append(errs, hh.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(fanout, len(h))
+	for i, hh := range h {
+		out[i] = hh.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (h fanout) WithGroup(name string) slog.Handler {
+	out := make(fanout, len(h))
+	for i, hh := range h {
+		out[i] = hh.WithGroup(name)
+	}
+	return out
+}
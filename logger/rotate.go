@@ -0,0 +1,153 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// rotatingFile is an io.Writer that appends to a log file and rotates it once
+// it would exceed maxSize bytes: path → path.1 → path.2 … keeping at most
+// maxBackups old files. Rotation happens inside Write under the same lock, so
+// concurrent writers never lose or split a line during the swap. With
+// compress, each backup is gzipped to path.N.gz in the background.
+type rotatingFile struct {
+	path       string
+	maxSize    int64 // 0 disables rotation
+	maxBackups int
+	compress   bool
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+
+	// compressing tracks the background compression of the newest backup.
+	compressing sync.WaitGroup
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int, compress bool) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, compress: compress}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens (or creates) the active log file. The caller must hold r.mu
+// unless r is not yet shared.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// Keep logging to the current file rather than dropping lines.
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups, moves the active file to path.1 and reopens a
+// fresh active file. The caller must hold r.mu.
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+
+	if r.maxBackups > 0 {
+		// The shift must not race with the compression of path.1. Plain and
+		// compressed backups are shifted alike, so turning compression on or
+		// off keeps the count.
+		r.compressing.Wait()
+		for _, ext := range []string{"", ".gz"} {
+			_ = os.Remove(r.backupName(r.maxBackups) + ext)
+			for i := r.maxBackups - 1; i >= 1; i-- {
+				_ = os.Rename(r.backupName(i)+ext, r.backupName(i+1)+ext)
+			}
+		}
+		if err := os.Rename(r.path, r.backupName(1)); err != nil {
+			_ = r.open()
+			return err
+		}
+		if r.compress {
+			r.compressing.Add(1)
+			go func(name string) {
+				defer r.compressing.Done()
+				if err := gzipFile(name); err != nil {
+					fmt.Fprintf(os.Stderr, "log compression failed: %v\n", err)
+				}
+			}(r.backupName(1))
+		}
+	} else if err := os.Truncate(r.path, 0); err != nil {
+		_ = r.open()
+		return err
+	}
+
+	return r.open()
+}
+
+func (r *rotatingFile) backupName(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
+
+// gzipFile compresses name to name.gz and removes name. The .gz file only
+// appears once it is complete.
+func gzipFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := name + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+".gz")
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	_ = src.Close()
+	return os.Remove(name)
+}
+
+// Close closes the active file after any background compression finished.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.compressing.Wait()
+	return r.f.Close()
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"os/signal"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"paperlesslink/config"
+	"paperlesslink/health"
 	"paperlesslink/logger"
+	"paperlesslink/sdnotify"
 	"paperlesslink/uploader"
 	"paperlesslink/watcher"
 )
@@ -18,93 +24,518 @@ import (
 // version is set at build time via -ldflags.
 var version = "dev"
 
+// forceExitDelay is how long uploads get to return after the shutdown
+// timeout cancelled them before PaperlessLink exits regardless.
+const forceExitDelay = 5 * time.Second
+
 func main() {
-	var (
-		dir          = flag.String("dir", "", "Directory to watch for new files (required)")
-		paperlessURL = flag.String("url", "", "Paperless-ngx base URL, e.g. https://paperless.example.com (required)")
-		token        = flag.String("token", "", "Paperless-ngx API token (required)")
-		ext          = flag.String("ext", "", "Comma-separated allowed file extensions, e.g. pdf,png (empty = all)")
-		renameUUID   = flag.Bool("rename-uuid", false, "Rename file to UUID before upload (original name used as title)")
-		afterUpload  = flag.String("after-upload", "delete", "Action after upload: delete | backup")
-		backupDir    = flag.String("backup-dir", "", "Backup directory (required when -after-upload=backup)")
-		logFile      = flag.String("log-file", "", "Path to log file (default: stdout only)")
-		pollInterval = flag.Duration("poll-interval", 5*time.Second, "Fallback poll interval for fsnotify")
-		showVersion  = flag.Bool("version", false, "Print version and exit")
-	)
-	flag.Parse()
+	if serviceCommand(os.Args[1:]) {
+		return
+	}
+	run(os.Args[1:], make(chan os.Signal, 2))
+}
+
+// run runs PaperlessLink with the command-line arguments args until it is
+// stopped by SIGINT or SIGTERM, which are delivered to sigs. A caller may
+// also send to sigs itself, as the Windows service handler does for a stop
+// request.
+func run(args []string, sigs chan os.Signal) {
+	cfg, opts, fs, err := parseConfig(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		os.Exit(2)
+	}
 
-	if *showVersion {
+	if opts.showVersion {
 		fmt.Println("paperlesslink", version)
 		os.Exit(0)
 	}
 
-	// Initialise logger first so all subsequent messages are structured.
-	cleanup, err := logger.Init(*logFile)
+	// Validate before initialising the logger, which depends on the log
+	// settings; errors until then go to stderr.
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration: %v\n", err)
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// The level and the extensions can change on SIGHUP.
+	var level slog.LevelVar
+	lvl, _ := cfg.SlogLevel() // validated above
+	level.Set(lvl)
+	var exts atomic.Pointer[config.Extensions]
+	exts.Store(&cfg.AllowedExts)
+
+	console := os.Stdout
+	if cfg.LogOutput == config.LogOutputStderr {
+		console = os.Stderr
+	}
+	cleanup, err := logger.Init(logger.Options{
+		File:       cfg.LogFile,
+		MaxSizeMB:  cfg.LogMaxSizeMB,
+		MaxBackups: cfg.LogMaxBackups,
+		Compress:   cfg.LogCompress,
+		Level:      &level,
+		Text:       cfg.LogFormat == config.LogFormatText,
+		Console:    console,
+		Quiet:      cfg.LogQuiet,
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to open log file: %v\n", err)
 		os.Exit(1)
 	}
 	defer cleanup()
 
-	slog.Info("PaperlessLink starting", "version", version)
+	slog.Info("PaperlessLink starting", "version", version, "config_file", opts.configFile)
 
-	cfg := &config.Config{
-		WatchDir:     *dir,
-		PaperlessURL: *paperlessURL,
-		Token:        *token,
-		AllowedExts:  config.ParseExtensions(*ext),
-		RenameToUUID: *renameUUID,
-		AfterUpload:  config.AfterUpload(*afterUpload),
-		BackupDir:    *backupDir,
-		LogFile:      *logFile,
-		PollInterval: *pollInterval,
-	}
-
-	if err := cfg.Validate(); err != nil {
-		slog.Error("invalid configuration", "error", err)
-		flag.Usage()
+	if err := cfg.LoadTokenFile(); err != nil {
+		slog.Error("cannot load API token", "error", err)
 		os.Exit(2)
 	}
+	slog.Info("effective configuration", "config", cfg.Redacted())
 
 	// Ensure backup directory exists when needed.
-	if cfg.AfterUpload == config.AfterUploadBackup {
+	if cfg.AfterUpload == config.AfterUploadBackup || cfg.OnDuplicate == config.OnDuplicateBackup {
 		if err := os.MkdirAll(cfg.BackupDir, 0o755); err != nil {
 			slog.Error("cannot create backup dir", "dir", cfg.BackupDir, "error", err)
 			os.Exit(1)
 		}
 	}
 
+	if cfg.ErrorDir != "" {
+		if err := os.MkdirAll(cfg.ErrorDir, 0o755); err != nil {
+			slog.Error("cannot create error dir", "dir", cfg.ErrorDir, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	up, err := uploader.New(cfg)
+	if err != nil {
+		slog.Error("failed to initialise uploader", "error", err)
+		os.Exit(1)
+	}
+
+	// Catch a wrong URL or token now rather than when the first file arrives.
+	if !cfg.SkipStartupCheck {
+		if err := up.Ping(context.Background()); err != nil {
+			slog.Error("cannot reach paperless (use -skip-startup-check to start anyway)", "url", cfg.PaperlessURL, "error", err)
+			os.Exit(1)
+		}
+		slog.Info("paperless connection ok", "url", cfg.PaperlessURL)
+	}
+
+	var probes *health.Server
+	if cfg.HealthAddr != "" {
+		probes, err = health.Start(health.Options{
+			Addr:     cfg.HealthAddr,
+			Check:    up.Ping,
+			Interval: cfg.HealthInterval,
+		})
+		if err != nil {
+			slog.Error("failed to start health endpoint", "error", err)
+			os.Exit(1)
+		}
+		defer probes.Close()
+	}
+
+	if cfg.PprofAddr != "" {
+		profiler, err := startPprof(cfg.PprofAddr)
+		if err != nil {
+			slog.Error("failed to start pprof endpoint", "error", err)
+			os.Exit(1)
+		}
+		defer profiler.Close()
+	}
+
 	stop := make(chan struct{})
 
-	files, err := watcher.Watch(cfg.WatchDir, cfg.AllowedExts, stop)
+	var (
+		running atomic.Int32
+		files   <-chan watcher.File
+		rescan  = make(chan struct{}, 1)
+	)
+	if cfg.Once {
+		files, err = scanOnce(cfg, stop, &exts)
+	} else {
+		files, err = startWatchers(cfg, stop, &running, &exts, rescan)
+	}
 	if err != nil {
 		slog.Error("failed to start watcher", "error", err)
 		os.Exit(1)
 	}
 
-	// Handle OS signals for graceful shutdown.
-	sigs := make(chan os.Signal, 1)
+	// ctx is cancelled once the shutdown timeout elapses, aborting any
+	// upload still in progress.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if cfg.OfflineQueue {
+		// Reachability checks of the offline queue end with the watchers, so
+		// one in progress does not hold up a shutdown.
+		queueCtx, stopQueue := context.WithCancel(ctx)
+		defer stopQueue()
+		go func() {
+			<-stop
+			stopQueue()
+		}()
+		files = drainQueue(queueCtx, files, up, cfg.RetryBaseDelay)
+	}
+	if probes != nil {
+		probes.SetWatching(true)
+	}
+	// A one-off run has nothing for systemd to supervise.
+	if !cfg.Once {
+		if ok, err := sdnotify.Notify(sdnotify.Ready); err != nil {
+			slog.Warn("cannot notify systemd", "error", err)
+		} else if ok {
+			go watchdog(stop, &running, int32(len(cfg.WatchDirs)))
+		}
+	}
+
+	// Handle OS signals for graceful shutdown: stop watching at once, but let
+	// in-flight and already queued uploads finish within -shutdown-timeout.
+	// Uploads that do not return once cancelled, or a second signal, end the
+	// process without waiting any longer.
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	busy := newInFlight()
+	var stats summary
 
 	go func() {
 		sig := <-sigs
-		slog.Info("received signal, shutting down", "signal", sig)
+		slog.Info("received signal, shutting down", "signal", sig, "timeout", cfg.ShutdownTimeout)
 		close(stop)
+		_, _ = sdnotify.Notify(sdnotify.Stopping)
+		if probes != nil {
+			probes.SetWatching(false)
+		}
+		forced := make(chan string, 1)
+		time.AfterFunc(cfg.ShutdownTimeout, func() {
+			slog.Warn("shutdown timeout elapsed, cancelling remaining uploads")
+			cancel()
+			time.AfterFunc(forceExitDelay, func() { forced <- "uploads did not stop after being cancelled" })
+		})
+		select {
+		case sig := <-sigs:
+			slog.Warn("received second signal, exiting immediately", "signal", sig)
+		case reason := <-forced:
+			slog.Error(reason + ", exiting")
+		}
+		if files := busy.list(); len(files) > 0 {
+			slog.Warn("abandoned uploads, files left for next run", "files", files)
+		}
+		stats.log()
+		cleanup()
+		os.Exit(1)
 	}()
 
+	// SIGHUP re-reads the configuration without dropping watchers or queued
+	// files.
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	go func() {
+		r := &reloader{args: args, current: cfg, level: &level, exts: &exts, up: up}
+		for range hups {
+			slog.Info("received SIGHUP, reloading configuration", "config_file", opts.configFile)
+			r.reload()
+		}
+	}()
+
+	// SIGUSR1 scans the watch directories again, e.g. to retry files left
+	// behind while Paperless was unreachable. Not available on Windows.
+	if !cfg.Once {
+		usr1 := make(chan os.Signal, 1)
+		notifyRescan(usr1)
+		go func() {
+			for range usr1 {
+				slog.Info("received SIGUSR1, rescanning watch directories")
+				select {
+				case rescan <- struct{}{}:
+				default: // a rescan is already pending
+				}
+			}
+		}()
+	}
+
 	slog.Info("watching for files",
-		"dir", cfg.WatchDir,
-		"extensions", *ext,
+		"dirs", cfg.WatchDirs,
+		"extensions", cfg.AllowedExts.String(),
 		"after_upload", cfg.AfterUpload,
+		"watch_mode", cfg.WatchMode,
 		"rename_uuid", cfg.RenameToUUID,
+		"concurrency", cfg.Concurrency,
+		"dry_run", cfg.DryRun,
+		"once", cfg.Once,
+	)
+	if cfg.DryRun {
+		slog.Warn("dry run: nothing will be uploaded, deleted or moved")
+	}
+
+	// Main upload loop: returns once files is closed and every in-flight
+	// upload has finished.
+	runWorkers(ctx, cfg.Concurrency, newLimiter(cfg.RateLimit), up, files, busy, &stats)
+	up.Close()
+
+	stats.log()
+	slog.Info("PaperlessLink stopped")
+	if cfg.Once && stats.failed.Load() > 0 {
+		cleanup()
+		os.Exit(1)
+	}
+}
+
+// runWorkers uploads files from the channel using n concurrent workers,
+// counting the outcomes in stats, and returns once the channel is closed and
+// all workers are idle. A path that is already being uploaded by one worker, as
+// recorded in busy, is skipped by the others, so a file reported twice in
+// quick succession is not uploaded twice. Once ctx is cancelled, remaining
+// queued files are left in place for the next run. Uploads across all
+// workers start no faster than lim allows.
+func runWorkers(ctx context.Context, n int, lim *limiter, up *uploader.Uploader, files <-chan watcher.File, busy *inFlight, stats *summary) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				filePath := f.Path
+				if ctx.Err() != nil {
+					slog.Warn("shutting down, file left for next run", "file", filePath)
+					continue
+				}
+
+				if !busy.start(filePath) {
+					slog.Debug("file already being uploaded, skipping", "file", filePath)
+					continue
+				}
+
+				if delay := lim.reserve(); delay > 0 {
+					slog.Info("rate limit reached, delaying upload", "file", filePath, "delay", delay.Round(time.Millisecond))
+					if err := sleep(ctx, delay); err != nil {
+						slog.Warn("shutting down, file left for next run", "file", filePath)
+					}
+				}
+				if ctx.Err() == nil {
+					res, err := up.Upload(ctx, filePath, f.Dir)
+					if err != nil {
+						slog.Error("upload error", "file", filePath, "upload_id", res.ID, "error", err)
+					}
+					stats.add(res, err)
+					// A queued file is not in Paperless yet.
+					f.Done(err == nil && !res.Queued)
+				}
+
+				busy.done(filePath)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// summary counts upload outcomes for the log line at shutdown.
+type summary struct {
+	uploaded, failed, skipped, queued atomic.Int64
+
+	// bytes is the total size of the uploaded files.
+	bytes atomic.Int64
+}
+
+// add counts the outcome of one upload.
+func (s *summary) add(res uploader.Result, err error) {
+	switch {
+	case err != nil:
+		s.failed.Add(1)
+	case res.Queued:
+		s.queued.Add(1)
+	case res.Skipped:
+		s.skipped.Add(1)
+	default:
+		s.uploaded.Add(1)
+		s.bytes.Add(res.Size)
+	}
+}
+
+// log writes the summary, as an error if any upload failed.
+func (s *summary) log() {
+	level := slog.LevelInfo
+	if s.failed.Load() > 0 {
+		level = slog.LevelError
+	}
+	slog.Log(context.Background(), level, "upload summary",
+		"uploaded", s.uploaded.Load(),
+		"failed", s.failed.Load(),
+		"skipped", s.skipped.Load(),
+		"queued", s.queued.Load(),
+		"bytes", s.bytes.Load(),
 	)
+}
+
+// inFlight is the set of files being uploaded.
+type inFlight struct {
+	mu    sync.Mutex
+	paths map[string]struct{}
+}
+
+func newInFlight() *inFlight {
+	return &inFlight{paths: make(map[string]struct{})}
+}
+
+// start adds path, reporting false if it is already being uploaded.
+func (f *inFlight) start(path string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.paths[path]; ok {
+		return false
+	}
+	f.paths[path] = struct{}{}
+	return true
+}
+
+// done removes path.
+func (f *inFlight) done(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.paths, path)
+}
+
+// list returns the paths being uploaded, sorted.
+func (f *inFlight) list() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Sorted(maps.Keys(f.paths))
+}
 
-	// Main upload loop.
-	for filePath := range files {
-		if err := uploader.Upload(cfg, filePath); err != nil {
-			slog.Error("upload error", "file", filePath, "error", err)
+// watchdog sends systemd watchdog keep-alives until stop is closed, but only
+// while all want watchers are running, so systemd restarts PaperlessLink when
+// one of them has died.
+func watchdog(stop <-chan struct{}, running *atomic.Int32, want int32) {
+	interval := sdnotify.WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	slog.Debug("systemd watchdog enabled", "interval", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if n := running.Load(); n < want {
+			slog.Error("watcher stopped, withholding systemd watchdog keep-alive", "running", n, "want", want)
+			continue
+		}
+		if _, err := sdnotify.Notify(sdnotify.Watchdog); err != nil {
+			slog.Warn("cannot notify systemd watchdog", "error", err)
 		}
 	}
+}
 
-	slog.Info("PaperlessLink stopped")
+// startWatchers starts one watcher per configured directory and fans their
+// output into a single channel, which is closed once every watcher has
+// stopped. running counts the watchers that have not stopped yet; exts holds
+// the allowed extensions, which may be replaced while running. Every receive
+// from rescan makes all watchers rescan their directory.
+func startWatchers(cfg *config.Config, stop <-chan struct{}, running *atomic.Int32, exts *atomic.Pointer[config.Extensions], rescan <-chan struct{}) (<-chan watcher.File, error) {
+	out := make(chan watcher.File)
+	var wg sync.WaitGroup
+	var rescans []chan struct{}
+
+	for _, dir := range cfg.WatchDirs {
+		opts := watchOptions(cfg, dir, exts)
+		r := make(chan struct{}, 1)
+		opts.Rescan = r
+		rescans = append(rescans, r)
+
+		files, err := watcher.Watch(opts, stop)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dir, err)
+		}
+
+		wg.Add(1)
+		running.Add(1)
+		go func() {
+			defer wg.Done()
+			defer running.Add(-1)
+			for f := range files {
+				out <- f
+			}
+		}()
+	}
+
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-rescan:
+			}
+			for _, r := range rescans {
+				select {
+				case r <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, nil
+}
+
+// scanOnce lists the files currently in the watch directories, for -once,
+// and sends them on the returned channel, which is closed after the last one
+// or once stop is closed.
+func scanOnce(cfg *config.Config, stop <-chan struct{}, exts *atomic.Pointer[config.Extensions]) (<-chan watcher.File, error) {
+	var all []watcher.File
+	for _, dir := range cfg.WatchDirs {
+		files, err := watcher.Scan(watchOptions(cfg, dir, exts))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dir, err)
+		}
+		all = append(all, files...)
+	}
+	slog.Info("found files to upload", "count", len(all))
+
+	out := make(chan watcher.File)
+	go func() {
+		defer close(out)
+		for _, f := range all {
+			select {
+			case out <- f:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// watchOptions returns the watcher settings for dir.
+func watchOptions(cfg *config.Config, dir string, exts *atomic.Pointer[config.Extensions]) watcher.Options {
+	return watcher.Options{
+		Dir:          dir,
+		AllowedExts:  func() map[string]struct{} { return *exts.Load() },
+		Include:      cfg.Include,
+		Exclude:      cfg.Exclude,
+		IgnoreTemp:   !cfg.NoIgnoreTemp,
+		Debounce:     cfg.Debounce,
+		Notify:       cfg.UsesFsnotify(),
+		Poll:         cfg.UsesPolling(),
+		PollInterval: cfg.PollInterval,
+
+		ProcessExisting: cfg.ProcessExisting,
+		Recursive:       cfg.Recursive,
+		FollowSymlinks:  cfg.FollowSymlinks,
+		CloseWrite:      cfg.CloseWrite,
+
+		StabilityInterval: cfg.StabilityInterval,
+		MinAge:            cfg.MinAge,
+		FileWaitTimeout:   cfg.FileWaitTimeout,
+		QueueSize:         cfg.QueueSize,
+	}
 }
@@ -0,0 +1,80 @@
+// Package manifest appends one JSON line per processed file to a ledger that,
+// unlike the log, is never rotated, so it can later be reconciled against
+// Paperless.
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Statuses reported in Entry.Status.
+const (
+	StatusUploaded = "uploaded"
+	StatusSkipped  = "skipped"
+	StatusQueued   = "queued"
+	StatusFailed   = "failed"
+)
+
+// Entry is one line of the manifest.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	UploadID string    `json:"upload_id"`
+	File     string    `json:"file"`
+	SHA256   string    `json:"sha256,omitempty"`
+	Size     int64     `json:"size"`
+	Status   string    `json:"status"`
+	Title    string    `json:"title,omitempty"`
+
+	// TaskID and DocumentID identify the document in Paperless; DocumentID
+	// is only known with -confirm-consumption.
+	TaskID     string `json:"task_id,omitempty"`
+	DocumentID int    `json:"document_id,omitempty"`
+
+	// Action is what was done with the file afterwards: delete, backup or
+	// keep, or error-dir for a failed upload; empty if it was left in place.
+	Action string `json:"action,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Manifest is an open manifest file. It is safe for concurrent use.
+type Manifest struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Open opens the manifest at path for appending, creating it if needed.
+func Open(path string) (*Manifest, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open manifest: %w", err)
+	}
+	return &Manifest{f: f}, nil
+}
+
+// Append writes e as one line and syncs it to disk, so an entry survives a
+// crash right after the upload it records.
+func (m *Manifest) Append(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, err := m.f.Write(line); err != nil {
+		return err
+	}
+	return m.f.Sync()
+}
+
+// Close closes the file.
+func (m *Manifest) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.f.Close()
+}
@@ -0,0 +1,125 @@
+// Package notify posts a small JSON message to a webhook (Slack, Discord,
+// ntfy, Home Assistant, ...) after an upload. Delivery happens in the
+// background and failures are only logged, so a slow or broken webhook never
+// holds up uploads.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// sendTimeout bounds a single webhook delivery.
+const sendTimeout = 10 * time.Second
+
+// Upload statuses reported in Message.Status.
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// Message is the JSON body posted to the webhook.
+type Message struct {
+	File   string `json:"file"`
+	Title  string `json:"title,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	// TaskID and DocumentID identify the upload in Paperless; DocumentID is
+	// only known with -confirm-consumption.
+	TaskID     string `json:"task_id,omitempty"`
+	DocumentID int    `json:"document_id,omitempty"`
+}
+
+// Notifier delivers messages to one webhook URL.
+type Notifier struct {
+	url       string
+	host      string // what is logged of url; the rest can hold its secret
+	userAgent string
+	client    *http.Client
+
+	wg sync.WaitGroup
+}
+
+// New returns a Notifier posting to webhookURL with the given User-Agent.
+func New(webhookURL, userAgent string) *Notifier {
+	return &Notifier{
+		url:       webhookURL,
+		host:      hostOf(webhookURL),
+		userAgent: userAgent,
+		client:    &http.Client{Timeout: sendTimeout},
+	}
+}
+
+// Send delivers msg in the background.
+func (n *Notifier) Send(msg Message) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		if err := n.post(msg); err != nil {
+			slog.Warn("notification failed", "host", n.host, "file", msg.File, "error", err)
+		}
+	}()
+}
+
+// Wait blocks until every message handed to Send has been delivered or has
+// failed.
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+func (n *Notifier) post(msg Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(payload))
+	if err != nil {
+		return n.redact(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", n.userAgent)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return n.redact(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned HTTP %d: %s", resp.StatusCode, body)
+	}
+	slog.Debug("notification sent", "host", n.host, "file", msg.File, "status", msg.Status)
+	return nil
+}
+
+// redact replaces the full URL that net/http quotes in its errors with the
+// host.
+func (n *Notifier) redact(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return fmt.Errorf("%s %s: %w", ue.Op, n.host, ue.Err)
+	}
+	return err
+}
+
+// hostOf returns the host of a webhook URL: Slack, Discord and ntfy keep the
+// secret in the path or query, so only the host is fit for the log.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "(invalid url)"
+	}
+	return u.Host
+}
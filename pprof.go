@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// startPprof serves the net/http/pprof handlers under /debug/pprof/ and the
+// expvar variables under /debug/vars on addr.
+func startPprof(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("pprof listener: %w", err)
+	}
+	srv := &http.Server{Handler: pprofMux(), ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("pprof server failed", "error", err)
+		}
+	}()
+
+	slog.Warn("pprof endpoint listening; do not expose it to untrusted networks", "addr", ln.Addr().String())
+	return srv, nil
+}
+
+// pprofMux returns the handlers of startPprof. They get their own mux so
+// nothing is exposed on http.DefaultServeMux. The command line is served
+// neither by pprof's cmdline handler nor among the expvar variables: it
+// would hand -token and -basic-auth to anyone reaching the listener.
+func pprofMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/vars", expvarHandler)
+	return mux
+}
+
+// expvarHandler is expvar.Handler without the "cmdline" variable that the
+// expvar package publishes on its own.
+func expvarHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprint(w, "{\n")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			fmt.Fprint(w, ",\n")
+		}
+		first = false
+		fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+	})
+	fmt.Fprint(w, "\n}\n")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestPprofHidesCmdline checks that nothing on the pprof listener hands out
+// the command line, which can hold -token and -basic-auth.
+func TestPprofHidesCmdline(t *testing.T) {
+	const secret = "s3cr3t-token-value"
+	args := os.Args
+	os.Args = append(slices.Clone(args), "-token", secret)
+	defer func() { os.Args = args }()
+
+	srv := httptest.NewServer(pprofMux())
+	defer srv.Close()
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/vars"} {
+		if _, body := get(path); strings.Contains(body, secret) {
+			t.Errorf("%s serves the command line", path)
+		}
+	}
+
+	status, body := get("/debug/vars")
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(body), &vars); err != nil {
+		t.Fatalf("/debug/vars is not JSON (HTTP %d): %v", status, err)
+	}
+	if _, ok := vars["cmdline"]; ok {
+		t.Error("/debug/vars lists cmdline")
+	}
+	if _, ok := vars["memstats"]; !ok {
+		t.Error("/debug/vars lacks memstats")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"paperlesslink/uploader"
+	"paperlesslink/watcher"
+)
+
+// maxQueueDelay caps the backoff between reachability checks while the
+// offline queue waits for Paperless.
+const maxQueueDelay = 5 * time.Minute
+
+// drainQueue forwards files and, once their Paperless instance is reachable
+// again, the files in the uploader's offline queue. The queue is checked
+// right away, picking up files queued before a restart, and then every base
+// interval, backing off up to maxQueueDelay while Paperless stays
+// unreachable. Once ctx is done the queue is no longer checked, but files are
+// still forwarded. The returned channel is closed once files is closed.
+func drainQueue(ctx context.Context, files <-chan watcher.File, up *uploader.Uploader, base time.Duration) <-chan watcher.File {
+	out := make(chan watcher.File)
+	go func() {
+		defer close(out)
+		delay := base
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		var pending []watcher.File
+		for {
+			// Only offer the next queued file while there is one.
+			var send chan<- watcher.File
+			var next watcher.File
+			if len(pending) > 0 {
+				send, next = out, pending[0]
+			}
+
+			select {
+			case f, ok := <-files:
+				if !ok {
+					return
+				}
+				out <- f
+			case send <- next:
+				pending = pending[1:]
+			case <-timer.C:
+				if len(pending) == 0 {
+					ready, waiting, err := up.Queued(ctx)
+					if ctx.Err() != nil {
+						continue
+					}
+					if len(ready) > 0 {
+						slog.Info("paperless reachable, uploading queued files", "queued", len(ready))
+						for _, q := range ready {
+							pending = append(pending, watcher.File{Path: q.Path, Dir: q.Dir})
+						}
+					}
+					if waiting > 0 {
+						delay = min(delay*2, maxQueueDelay)
+						slog.Info("paperless still unreachable, keeping files queued", "queued", waiting, "next_check", delay, "error", err)
+					} else {
+						delay = base
+					}
+				}
+				timer.Reset(delay)
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"paperlesslink/config"
+	"paperlesslink/state"
+	"paperlesslink/uploader"
+	"paperlesslink/watcher"
+)
+
+// TestDrainQueueStopsProbe cancels the context while a reachability check of
+// the offline queue hangs on an unresponsive Paperless: the queue must still
+// close promptly once the watchers are done, not after the HTTP timeout.
+func TestDrainQueueStopsProbe(t *testing.T) {
+	probing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case probing <- struct{}{}:
+		default:
+		}
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scan.pdf")
+	if err := os.WriteFile(path, []byte("%PDF-1.4 queued"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	store, err := state.Open(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Enqueue(path, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	up, err := uploader.New(&config.Config{
+		PaperlessURL: srv.URL,
+		Token:        "token",
+		APIPath:      "/api",
+		StateFile:    stateFile,
+		OfflineQueue: true,
+		DialTimeout:  time.Second,
+		HTTPTimeout:  time.Minute,
+		UserAgent:    "paperlesslink/test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer up.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	files := make(chan watcher.File)
+	out := drainQueue(ctx, files, up, time.Second)
+	select {
+	case <-probing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("offline queue not checked")
+	}
+
+	cancel()
+	close(files)
+	select {
+	case f, ok := <-out:
+		if ok {
+			t.Fatalf("unexpected file %s", f.Path)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("queue did not close after cancelling; the reachability check ignores the context")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"sync/atomic"
+
+	"paperlesslink/config"
+	"paperlesslink/uploader"
+)
+
+// reloadable lists the config keys that take effect on SIGHUP. Everything
+// else, such as the watch directories, needs a restart. After a reload,
+// exactly these keys are copied into the current configuration.
+var reloadable = []string{"ext", "tags", "consumption_tag", "correspondent", "document_type", "storage_path", "custom_field", "owner", "view_users", "view_groups", "edit_users", "edit_groups", "title_template", "title_transform", "note_template", "log_level"}
+
+// reloader re-reads the configuration on SIGHUP and applies the reloadable
+// settings to the running watchers, uploader and logger.
+type reloader struct {
+	args []string
+
+	// current is the configuration in effect: the startup values, with the
+	// reloadable ones replaced by the last successful reload.
+	current *config.Config
+
+	level *slog.LevelVar
+	exts  *atomic.Pointer[config.Extensions]
+	up    *uploader.Uploader
+}
+
+// reload parses the command line, config file and environment again. On any
+// error the current configuration stays in effect.
+func (r *reloader) reload() {
+	next, _, _, err := parseConfig(r.args)
+	if err == nil {
+		err = next.Validate()
+	}
+	if err == nil {
+		err = next.LoadTokenFile()
+	}
+	if err != nil {
+		slog.Error("reload failed, keeping current configuration", "error", err)
+		return
+	}
+	if err := r.up.Reload(next); err != nil {
+		slog.Error("reload failed, keeping current configuration", "error", err)
+		return
+	}
+	level, _ := next.SlogLevel() // validated above
+	r.level.Set(level)
+	r.exts.Store(&next.AllowedExts)
+
+	var applied, ignored []string
+	for _, key := range config.Changed(r.current, next) {
+		if slices.Contains(reloadable, key) {
+			applied = append(applied, key)
+		} else {
+			ignored = append(ignored, key)
+		}
+	}
+	if len(ignored) > 0 {
+		slog.Warn("changed settings need a restart, ignoring them", "keys", ignored)
+	}
+
+	cur := *r.current
+	config.CopyKeys(&cur, next, reloadable)
+	r.current = &cur
+
+	slog.Info("configuration reloaded", "applied", applied)
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyRescan relays SIGUSR1, which requests a rescan, to c.
+func notifyRescan(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR1)
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// notifyRescan does nothing: Windows has no SIGUSR1.
+func notifyRescan(c chan<- os.Signal) {}
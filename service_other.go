@@ -0,0 +1,7 @@
+//go:build !windows
+
+package main
+
+// serviceCommand handles the install, uninstall and run subcommands of the
+// Windows service; elsewhere there are none, so it always reports false.
+func serviceCommand(args []string) bool { return false }
@@ -0,0 +1,169 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name PaperlessLink is registered under with the
+// Service Control Manager.
+const serviceName = "PaperlessLink"
+
+// serviceCommand handles the subcommands that manage the Windows service,
+// exiting on failure, and reports whether args started with one:
+//
+//	install [flags]  register the service to start with flags at boot
+//	uninstall        remove the service
+//	run [flags]      run under the Service Control Manager
+func serviceCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	var err error
+	switch args[0] {
+	case "install":
+		err = installService(args[1:])
+	case "uninstall":
+		err = uninstallService()
+	case "run":
+		err = runService(args[1:])
+	default:
+		return false
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "service %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	return true
+}
+
+// installService registers the running executable as an automatically
+// started service that runs with args. The service runs with
+// C:\Windows\System32 as its working directory, so paths in args and in the
+// config file should be absolute.
+func installService(args []string) error {
+	// Reject a configuration that would only fail once the service starts.
+	cfg, _, _, err := parseConfig(args)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "PaperlessLink",
+		Description: "Uploads documents from watched folders to Paperless-ngx",
+		StartType:   mgr.StartAutomatic,
+	}, append([]string{"run"}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// Restart after a crash, as systemd's Restart=on-failure would.
+	restart := []mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 10 * time.Second}}
+	if err := s.SetRecoveryActions(restart, uint32((24 * time.Hour).Seconds())); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot set service recovery actions: %v\n", err)
+	}
+	fmt.Printf("service %s installed; start it with: sc start %s\n", serviceName, serviceName)
+	return nil
+}
+
+// uninstallService removes the service. A running service is removed once
+// it stops.
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	fmt.Printf("service %s removed\n", serviceName)
+	return nil
+}
+
+// runService runs PaperlessLink with args under the Service Control Manager,
+// or in the console when started from one, which helps testing the
+// service's arguments.
+func runService(args []string) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		run(args, make(chan os.Signal, 2))
+		return nil
+	}
+	return svc.Run(serviceName, &service{args: args})
+}
+
+// service is the svc.Handler running PaperlessLink.
+type service struct {
+	args []string
+}
+
+// Execute runs PaperlessLink until it stops by itself or the Service Control
+// Manager asks it to. A stop or shutdown request is turned into SIGTERM, so
+// it takes the same graceful shutdown path as on other platforms.
+func (s *service) Execute(_ []string, reqs <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	sigs := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(s.args, sigs)
+	}()
+
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.Running, Accepts: accepts}
+	for {
+		select {
+		case <-done:
+			status <- svc.Status{State: svc.StopPending}
+			return false, 0
+		case req := <-reqs:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				// A second request exits at once, like a second signal.
+				select {
+				case sigs <- syscall.SIGTERM:
+				default:
+				}
+			}
+		}
+	}
+}
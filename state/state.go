@@ -0,0 +1,179 @@
+// Package state persists what PaperlessLink has already uploaded across
+// restarts, so a file that survives a crash between upload and post-upload
+// action (or is dropped again by a flaky scanner) is not uploaded twice.
+//
+// The store is a single JSON file rewritten atomically (temp file + rename)
+// on every change.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Store is a persistent set of SHA-256 checksums of uploaded files, plus the
+// archive serial number counter and the queue of files waiting for Paperless
+// to come back. It is safe for concurrent use.
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	data fileData
+}
+
+// fileData is the on-disk layout of the state file.
+type fileData struct {
+	// Uploaded maps hex SHA-256 → time of the successful upload.
+	Uploaded map[string]time.Time `json:"uploaded"`
+
+	// NextASN is the next archive serial number to assign; 0 until the
+	// first one is committed.
+	NextASN int `json:"next_asn,omitempty"`
+
+	// Queued maps file path → queue entry for uploads postponed because
+	// Paperless was unreachable.
+	Queued map[string]QueuedFile `json:"queued,omitempty"`
+}
+
+// QueuedFile is a file waiting to be uploaded once Paperless is reachable.
+type QueuedFile struct {
+	Path string `json:"-"`
+
+	// Dir is the watch directory the file was found in.
+	Dir string `json:"dir"`
+
+	// Since is when the file was first queued.
+	Since time.Time `json:"since"`
+}
+
+// Open loads the store at path, starting empty if the file does not exist.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	raw, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("read state file: %w", err)
+	default:
+		if err := json.Unmarshal(raw, &s.data); err != nil {
+			return nil, fmt.Errorf("parse state file %s: %w", path, err)
+		}
+	}
+	if s.data.Uploaded == nil {
+		s.data.Uploaded = make(map[string]time.Time)
+	}
+	return s, nil
+}
+
+// Has reports whether a file with checksum sum was already uploaded.
+func (s *Store) Has(sum string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.data.Uploaded[sum]
+	return ok
+}
+
+// Add records checksum sum as uploaded and persists the store.
+func (s *Store) Add(sum string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Uploaded[sum] = time.Now().UTC()
+	return s.save()
+}
+
+// NextASN returns the archive serial number to assign to the next upload:
+// the stored counter, but never less than start.
+func (s *Store) NextASN(start int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return max(s.data.NextASN, start)
+}
+
+// CommitASN records asn as used, so NextASN returns a higher number from now
+// on, and persists the store.
+func (s *Store) CommitASN(asn int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if asn < s.data.NextASN {
+		return nil
+	}
+	s.data.NextASN = asn + 1
+	return s.save()
+}
+
+// Enqueue records filePath, found in the watch directory dir, as waiting for
+// upload and persists the store. A file that is already queued keeps its
+// original entry.
+func (s *Store) Enqueue(filePath, dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Queued[filePath]; ok {
+		return nil
+	}
+	if s.data.Queued == nil {
+		s.data.Queued = make(map[string]QueuedFile)
+	}
+	s.data.Queued[filePath] = QueuedFile{Dir: dir, Since: time.Now().UTC()}
+	return s.save()
+}
+
+// Dequeue removes filePath from the queue, persisting the store only if it
+// was queued.
+func (s *Store) Dequeue(filePath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Queued[filePath]; !ok {
+		return nil
+	}
+	delete(s.data.Queued, filePath)
+	return s.save()
+}
+
+// Queued returns the queued files, oldest first.
+func (s *Store) Queued() []QueuedFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := make([]QueuedFile, 0, len(s.data.Queued))
+	for path, q := range s.data.Queued {
+		q.Path = path
+		files = append(files, q)
+	}
+	slices.SortFunc(files, func(a, b QueuedFile) int { return a.Since.Compare(b.Since) })
+	return files
+}
+
+// save writes the store to disk atomically. The caller must hold s.mu.
+func (s *Store) save() error {
+	raw, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".paperlesslink-state-*")
+	if err != nil {
+		return fmt.Errorf("create temp state file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := tmp.Write(raw); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write temp state file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("sync temp state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temp state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replace state file: %w", err)
+	}
+	return nil
+}
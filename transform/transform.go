@@ -0,0 +1,99 @@
+// Package transform implements -title-transform: an ordered list of named
+// clean-up steps applied to the file name stem that becomes the document
+// title, such as stripping a scanner's prefix or replacing underscores.
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// Pipeline is a parsed list of steps, applied in order. The zero value
+// returns its input unchanged.
+type Pipeline []func(string) string
+
+// leadingCounter matches a number at the start of a name and the separators
+// after it, as in "001_invoice" or "12 - letter".
+var leadingCounter = regexp.MustCompile(`^\d+[\s_.-]*`)
+
+// Parse parses specs, each a step "name" or "name:argument":
+//
+//	strip-prefix:P  remove P from the start, if it is there
+//	strip-suffix:S  remove S from the end, if it is there
+//	strip-counter   remove a leading number and the separators after it
+//	replace:A=B     replace every A with B; the word "space" stands for " ",
+//	                and an empty B removes A
+//	trim            remove spaces, '_', '-' and '.' from both ends
+//	lowercase       lower-case everything
+//	uppercase       upper-case everything
+//	titlecase       upper-case the first letter of every word, leaving the
+//	                others as they are; run lowercase first to normalise them
+func Parse(specs []string) (Pipeline, error) {
+	var p Pipeline
+	for _, spec := range specs {
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(spec), ":")
+		step, err := parseStep(name, arg, hasArg)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", spec, err)
+		}
+		p = append(p, step)
+	}
+	return p, nil
+}
+
+func parseStep(name, arg string, hasArg bool) (func(string) string, error) {
+	needsArg := name == "strip-prefix" || name == "strip-suffix" || name == "replace"
+	switch {
+	case needsArg && arg == "":
+		return nil, fmt.Errorf("%s needs an argument, as in %s:…", name, name)
+	case !needsArg && hasArg:
+		return nil, fmt.Errorf("%s takes no argument", name)
+	}
+
+	switch name {
+	case "strip-prefix":
+		return func(s string) string { return strings.TrimPrefix(s, arg) }, nil
+	case "strip-suffix":
+		return func(s string) string { return strings.TrimSuffix(s, arg) }, nil
+	case "strip-counter":
+		return func(s string) string { return leadingCounter.ReplaceAllString(s, "") }, nil
+	case "replace":
+		from, to, ok := strings.Cut(arg, "=")
+		if !ok || from == "" {
+			return nil, errors.New("replace needs OLD=NEW, as in replace:_=space")
+		}
+		from, to = word(from), word(to)
+		return func(s string) string { return strings.ReplaceAll(s, from, to) }, nil
+	case "trim":
+		return func(s string) string { return strings.Trim(s, " \t_-.") }, nil
+	case "lowercase":
+		return strings.ToLower, nil
+	case "uppercase":
+		return strings.ToUpper, nil
+	case "titlecase":
+		// A Caser is not safe for concurrent use, so make one per call.
+		return func(s string) string { return cases.Title(language.Und, cases.NoLower).String(s) }, nil
+	}
+	return nil, fmt.Errorf("unknown transform %q", name)
+}
+
+// word resolves the stand-in "space" of a replace argument.
+func word(s string) string {
+	if s == "space" {
+		return " "
+	}
+	return s
+}
+
+// Apply runs s through every step of p.
+func (p Pipeline) Apply(s string) string {
+	for _, step := range p {
+		s = step(s)
+	}
+	return s
+}
@@ -0,0 +1,99 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestSteps runs every step on its own.
+func TestSteps(t *testing.T) {
+	for _, tt := range []struct {
+		spec, in, want string
+	}{
+		{"strip-prefix:SCAN_", "SCAN_invoice", "invoice"},
+		{"strip-prefix:SCAN_", "invoice_SCAN_", "invoice_SCAN_"},
+		{"strip-prefix:SCAN_", "SCAN_SCAN_x", "SCAN_x"},
+		{"strip-prefix:SCAN_", "scan_invoice", "scan_invoice"},
+
+		{"strip-suffix:_ocr", "invoice_ocr", "invoice"},
+		{"strip-suffix:_ocr", "_ocr_invoice", "_ocr_invoice"},
+
+		{"strip-counter", "001_invoice", "invoice"},
+		{"strip-counter", "12 - letter", "letter"},
+		{"strip-counter", "007. memo", "memo"},
+		{"strip-counter", "invoice 001", "invoice 001"},
+		{"strip-counter", "42", ""},
+
+		{"replace:_=space", "tax_return_2024", "tax return 2024"},
+		{"replace:space=_", "tax return", "tax_return"},
+		{"replace:-=", "a-b-c", "abc"},
+		{"replace:Rg=Rechnung", "Rg 17 Rg", "Rechnung 17 Rechnung"},
+		{"replace:x=y", "none here", "none here"},
+
+		{"trim", "  _invoice- .", "invoice"},
+		{"trim", "in_voice", "in_voice"},
+		{"trim", "___", ""},
+
+		{"lowercase", "Invoice ÄÖÜ", "invoice äöü"},
+		{"uppercase", "Invoice äöü", "INVOICE ÄÖÜ"},
+
+		{"titlecase", "tax return 2024", "Tax Return 2024"},
+		{"titlecase", "über mcDonald", "Über McDonald"},
+		{"titlecase", "IBAN statement", "IBAN Statement"},
+	} {
+		t.Run(tt.spec+" "+tt.in, func(t *testing.T) {
+			p, err := Parse([]string{tt.spec})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.Apply(tt.in); got != tt.want {
+				t.Errorf("%s on %q = %q, want %q", tt.spec, tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		specs []string
+		in    string
+		want  string
+	}{
+		{"empty", nil, "SCAN_001_x", "SCAN_001_x"},
+		{"scanner name", []string{"strip-prefix:SCAN_", "strip-counter", "replace:_=space", "trim", "lowercase", "titlecase"}, "SCAN_0042_TAX_return__", "Tax Return"},
+		{"order matters", []string{"titlecase", "lowercase"}, "tax return", "tax return"},
+		{"spaces around specs", []string{" trim ", " uppercase"}, " a ", "A"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse(tt.specs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.Apply(tt.in); got != tt.want {
+				t.Errorf("Apply(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tt := range []struct {
+		spec, wantErr string
+	}{
+		{"strip-prefix", "needs an argument"},
+		{"strip-prefix:", "needs an argument"},
+		{"strip-suffix", "needs an argument"},
+		{"replace", "needs an argument"},
+		{"replace:_", "replace needs OLD=NEW"},
+		{"replace:=x", "replace needs OLD=NEW"},
+		{"trim:x", "takes no argument"},
+		{"lowercase:x", "takes no argument"},
+		{"reverse", "unknown transform"},
+	} {
+		_, err := Parse([]string{tt.spec})
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Parse(%q) error = %v, want one containing %q", tt.spec, err, tt.wantErr)
+		}
+	}
+}
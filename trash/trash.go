@@ -0,0 +1,59 @@
+// Package trash moves files to the desktop trash or recycle bin, from where
+// they can be restored, instead of deleting them for good.
+package trash
+
+import (
+	"io"
+	"os"
+)
+
+// Available returns an error if there is no trash on this system to move
+// files to, so that this can be reported before any file is touched.
+func Available() error {
+	return available()
+}
+
+// Move moves the file at path to the trash.
+func Move(path string) error {
+	return move(path)
+}
+
+// moveFile moves src to dst, copying and deleting src when they are on
+// different filesystems. dst must not exist yet.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies src to the new file dst, removing a partial copy on
+// failure.
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Sync()
+}
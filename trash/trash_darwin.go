@@ -0,0 +1,51 @@
+package trash
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// userTrash returns ~/.Trash, the Finder's trash.
+func userTrash() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".Trash"), nil
+}
+
+func available() error {
+	if _, err := userTrash(); err != nil {
+		return fmt.Errorf("no trash directory: %w", err)
+	}
+	return nil
+}
+
+// move moves path into ~/.Trash under a free name, copying it there from
+// another volume. The Finder lists it, but cannot put it back, as it only
+// records the origin of files it trashed itself.
+func move(path string) error {
+	dir, err := userTrash()
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for n := 1; ; n++ {
+		dst := filepath.Join(dir, name)
+		if n > 1 {
+			dst = filepath.Join(dir, fmt.Sprintf("%s %d%s", stem, n, ext))
+		}
+		if _, err := os.Lstat(dst); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return moveFile(path, dst)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package trash
+
+import "errors"
+
+var errUnsupported = errors.New("moving files to the trash is not supported on this platform")
+
+func available() error {
+	return errUnsupported
+}
+
+func move(path string) error {
+	return errUnsupported
+}
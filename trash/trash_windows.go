@@ -0,0 +1,78 @@
+package trash
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procSHFileOperationW = windows.NewLazySystemDLL("shell32.dll").NewProc("SHFileOperationW")
+
+// shFileOpStruct is SHFILEOPSTRUCTW, laid out as on 64-bit Windows; 32-bit
+// Windows packs it differently.
+type shFileOpStruct struct {
+	hwnd          windows.HWND
+	wFunc         uint32
+	pFrom         *uint16
+	pTo           *uint16
+	fFlags        uint16
+	anyAborted    int32
+	nameMappings  uintptr
+	progressTitle *uint16
+}
+
+const (
+	foDelete          = 0x3
+	fofSilent         = 0x4
+	fofNoConfirmation = 0x10
+	fofAllowUndo      = 0x40
+	fofNoErrorUI      = 0x400
+)
+
+func available() error {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		return errors.New("moving files to the recycle bin is only supported on 64-bit Windows")
+	}
+	return nil
+}
+
+// move sends path to the recycle bin through the shell, without any dialog.
+// Only local fixed drives have a recycle bin; for a file elsewhere, as on a
+// network share or USB stick, the shell would delete it for good, so move
+// refuses it instead.
+func move(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	root := filepath.VolumeName(abs) + `\`
+	rootp, err := windows.UTF16PtrFromString(root)
+	if err != nil {
+		return err
+	}
+	if windows.GetDriveType(rootp) != windows.DRIVE_FIXED {
+		return fmt.Errorf("%s has no recycle bin, only local fixed drives have one", root)
+	}
+
+	// pFrom is a list of paths, which ends with an empty one.
+	from, err := windows.UTF16FromString(abs)
+	if err != nil {
+		return err
+	}
+	from = append(from, 0)
+	op := shFileOpStruct{
+		wFunc:  foDelete,
+		pFrom:  &from[0],
+		fFlags: fofAllowUndo | fofNoConfirmation | fofSilent | fofNoErrorUI,
+	}
+	if r, _, _ := procSHFileOperationW.Call(uintptr(unsafe.Pointer(&op))); r != 0 {
+		return fmt.Errorf("SHFileOperation failed with code %#x", r)
+	}
+	if op.anyAborted != 0 {
+		return errors.New("moving to the recycle bin was aborted")
+	}
+	return nil
+}
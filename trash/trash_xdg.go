@@ -0,0 +1,97 @@
+//go:build unix && !darwin
+
+package trash
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// homeTrash returns the home trash of the freedesktop.org trash
+// specification, $XDG_DATA_HOME/Trash or by default ~/.local/share/Trash,
+// as used by GNOME, KDE and most other desktops.
+func homeTrash() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "Trash"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "Trash"), nil
+}
+
+func available() error {
+	if _, err := homeTrash(); err != nil {
+		return fmt.Errorf("no trash directory: %w", err)
+	}
+	return nil
+}
+
+// move claims a free name in the home trash by creating its .trashinfo
+// file, which records where the file came from so a file manager can
+// restore it, and then moves the file into files/ under that name. A file
+// on another filesystem is copied into the home trash, as the specification
+// permits, rather than into a trash directory on its own volume.
+func move(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	dir, err := homeTrash()
+	if err != nil {
+		return err
+	}
+	filesDir, infoDir := filepath.Join(dir, "files"), filepath.Join(dir, "info")
+	for _, d := range []string{filesDir, infoDir} {
+		if err := os.MkdirAll(d, 0o700); err != nil {
+			return err
+		}
+	}
+
+	info := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		(&url.URL{Path: abs}).EscapedPath(),
+		time.Now().Format("2006-01-02T15:04:05"),
+	)
+	name := filepath.Base(abs)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for n := 1; ; n++ {
+		candidate := name
+		if n > 1 {
+			candidate = fmt.Sprintf("%s.%d%s", stem, n, ext)
+		}
+		infoPath := filepath.Join(infoDir, candidate+".trashinfo")
+		f, err := os.OpenFile(infoPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString(info)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		dst := filepath.Join(filesDir, candidate)
+		if err == nil {
+			// A file left without its .trashinfo still holds the name.
+			if _, serr := os.Lstat(dst); serr == nil {
+				os.Remove(infoPath)
+				continue
+			}
+			err = moveFile(abs, dst)
+		}
+		if err != nil {
+			os.Remove(infoPath)
+			return err
+		}
+		return nil
+	}
+}
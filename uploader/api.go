@@ -0,0 +1,301 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"paperlesslink/config"
+)
+
+// paperless is one Paperless instance uploads can go to: the default one
+// given by -url and -token, or a named target from the config file. Name
+// lookups are cached per instance since IDs differ between them.
+type paperless struct {
+	name   string // "" for the default instance
+	url    string
+	token  string // empty with -basic-auth
+	cfg    *config.Config
+	client *http.Client
+	ids    idCache
+
+	// offline is set when an upload or ping found the instance unreachable
+	// and cleared by the next successful ping; while set, files for it go
+	// straight to the offline queue.
+	offline atomic.Bool
+
+	breaker breaker
+}
+
+// apiURL returns the absolute URL of path, such as "/tags/" or
+// "/tasks/?task_id=…", below the Paperless API root, keeping the trailing
+// slash Django expects. A query string is split off first, as JoinPath would
+// escape its '?'.
+func (p *paperless) apiURL(path string) string {
+	path, query, _ := strings.Cut(path, "?")
+	// p.url was validated with the config, so it parses.
+	u, _ := url.JoinPath(p.url, p.cfg.APIPath, path)
+	if query != "" {
+		u += "?" + query
+	}
+	return u
+}
+
+// newRequest builds a request to Paperless with authentication and
+// User-Agent headers set.
+func (p *paperless) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, p.apiURL(path), body)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Token "+p.token)
+	} else {
+		user, password, _ := p.cfg.ParseBasicAuth()
+		req.SetBasicAuth(user, password)
+	}
+	req.Header.Set("User-Agent", p.cfg.UserAgent)
+	return req, nil
+}
+
+// Ping checks that Paperless is reachable and accepts the API token with a
+// lightweight GET /api/, for the default instance and every target. It
+// returns the first failure, but checks every instance.
+func (u *Uploader) Ping(ctx context.Context) error {
+	var first error
+	for _, name := range slices.Sorted(maps.Keys(u.targets)) {
+		err := u.targets[name].ping(ctx)
+		if err != nil && name != "" {
+			err = fmt.Errorf("target %s: %w", name, err)
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// ping implements Ping for a single instance. It also records whether the
+// instance is unreachable, which decides if new files for it go straight to
+// the offline queue.
+func (p *paperless) ping(ctx context.Context) error {
+	var root json.RawMessage
+	err := p.getJSON(ctx, "/", nil, &root)
+	if ctx.Err() == nil {
+		p.offline.Store(err != nil && unreachable(err))
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && (httpErr.StatusCode == http.StatusUnauthorized || httpErr.StatusCode == http.StatusForbidden) {
+		if p.token == "" {
+			return fmt.Errorf("basic auth credentials rejected: %w", err)
+		}
+		return fmt.Errorf("API token rejected: %w", err)
+	}
+	return err
+}
+
+// getJSON performs an authenticated GET and decodes the JSON response into v.
+func (p *paperless) getJSON(ctx context.Context, path string, query url.Values, v any) error {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	req, err := p.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http get: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return newHTTPError(resp, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode response from %s: %w", path, err)
+	}
+	return nil
+}
+
+// postJSON performs an authenticated POST of in as JSON and decodes the
+// response into out.
+func (p *paperless) postJSON(ctx context.Context, path string, in, out any) error {
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encode request for %s: %w", path, err)
+	}
+	req, err := p.newRequest(ctx, http.MethodPost, path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http post: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return newHTTPError(resp, body)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response from %s: %w", path, err)
+	}
+	return nil
+}
+
+// namedObject is the common shape of Paperless tags, correspondents,
+// document types and similar objects in list responses. Users carry a
+// username instead of a name.
+type namedObject struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+}
+
+// listResponse is the paginated envelope of Paperless list endpoints.
+type listResponse[T any] struct {
+	Count   int `json:"count"`
+	Results []T `json:"results"`
+}
+
+// idCache memoises name → ID lookups for the process lifetime.
+type idCache struct {
+	mu  sync.Mutex
+	ids map[string]int // "endpoint\x00name" → ID
+
+	// resolving serialises lookups that miss the cache so concurrent uploads
+	// never create the same missing object twice.
+	resolving sync.Mutex
+}
+
+func (c *idCache) get(endpoint, name string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id, ok := c.ids[endpoint+"\x00"+name]
+	return id, ok
+}
+
+func (c *idCache) put(endpoint, name string, id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ids == nil {
+		c.ids = make(map[string]int)
+	}
+	c.ids[endpoint+"\x00"+name] = id
+}
+
+// creatable lists the endpoints whose objects can be created from just a
+// name. Storage paths, for example, also need a path template.
+var creatable = map[string]bool{
+	"/tags/":           true,
+	"/correspondents/": true,
+	"/document_types/": true,
+}
+
+// usersEndpoint is looked up by username rather than name.
+const usersEndpoint = "/users/"
+
+// resolveID turns ref into a Paperless object ID. Numeric refs are used as
+// is; anything else is looked up by name on endpoint (e.g. "/tags/") and,
+// with -create-missing-metadata, created when it does not exist yet.
+func (p *paperless) resolveID(ctx context.Context, log *slog.Logger, endpoint, ref string) (int, error) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		return id, nil
+	}
+	if id, ok := p.ids.get(endpoint, ref); ok {
+		return id, nil
+	}
+
+	p.ids.resolving.Lock()
+	defer p.ids.resolving.Unlock()
+	if id, ok := p.ids.get(endpoint, ref); ok {
+		return id, nil
+	}
+
+	// Paperless has no exact-match name filter, so use the case-insensitive
+	// one and pick the exact match from the results.
+	field := "name"
+	if endpoint == usersEndpoint {
+		field = "username"
+	}
+	var list listResponse[namedObject]
+	if err := p.getJSON(ctx, endpoint, url.Values{field + "__iexact": {ref}}, &list); err != nil {
+		return 0, fmt.Errorf("look up %q on %s: %w", ref, endpoint, err)
+	}
+	if endpoint == usersEndpoint {
+		for i := range list.Results {
+			list.Results[i].Name = list.Results[i].Username
+		}
+	}
+	id, ok := matchName(list.Results, ref)
+	if !ok {
+		if !p.cfg.CreateMissingMetadata || !creatable[endpoint] {
+			return 0, &permanentError{fmt.Errorf("%q not found on %s", ref, endpoint)}
+		}
+		var created namedObject
+		if err := p.postJSON(ctx, endpoint, map[string]string{"name": ref}, &created); err != nil {
+			return 0, fmt.Errorf("create %q on %s: %w", ref, endpoint, err)
+		}
+		log.Info("created missing paperless object", "endpoint", endpoint, "name", ref, "id", created.ID)
+		id = created.ID
+	}
+	p.ids.put(endpoint, ref, id)
+	return id, nil
+}
+
+// matchName returns the ID of the object named name, preferring an exact
+// match over a case-insensitive one.
+func matchName(objs []namedObject, name string) (int, bool) {
+	for _, o := range objs {
+		if o.Name == name {
+			return o.ID, true
+		}
+	}
+	for _, o := range objs {
+		if strings.EqualFold(o.Name, name) {
+			return o.ID, true
+		}
+	}
+	return 0, false
+}
+
+// resolveOptionalID resolves ref like resolveID, returning 0 when ref is empty.
+func (p *paperless) resolveOptionalID(ctx context.Context, log *slog.Logger, endpoint, ref string) (int, error) {
+	if ref == "" {
+		return 0, nil
+	}
+	return p.resolveID(ctx, log, endpoint, ref)
+}
+
+// resolveIDs resolves every ref in refs against endpoint.
+func (p *paperless) resolveIDs(ctx context.Context, log *slog.Logger, endpoint string, refs []string) ([]int, error) {
+	ids := make([]int, 0, len(refs))
+	for _, ref := range refs {
+		id, err := p.resolveID(ctx, log, endpoint, ref)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,29 @@
+package uploader
+
+import (
+	"testing"
+
+	"paperlesslink/config"
+)
+
+func TestAPIURL(t *testing.T) {
+	for _, tt := range []struct {
+		name, base, apiPath, path, want string
+	}{
+		{"root host", "https://paperless.example.com", "/api", "/tags/", "https://paperless.example.com/api/tags/"},
+		{"root host with slash", "https://paperless.example.com/", "/api", "/tags/", "https://paperless.example.com/api/tags/"},
+		{"api root", "https://paperless.example.com", "/api", "/", "https://paperless.example.com/api/"},
+		{"subpath host", "https://example.com/paperless", "/api", "/documents/post_document/", "https://example.com/paperless/api/documents/post_document/"},
+		{"subpath host with slash", "https://example.com/paperless/", "/api", "/tags/", "https://example.com/paperless/api/tags/"},
+		{"custom api path", "https://example.com", "/paperless-api/", "/tags/", "https://example.com/paperless-api/tags/"},
+		{"query", "https://paperless.example.com", "/api", "/tasks/?task_id=4a9a1a56", "https://paperless.example.com/api/tasks/?task_id=4a9a1a56"},
+		{"query on subpath host", "https://example.com/paperless", "/api", "/tags/?name__iexact=Tax%202024&page_size=1", "https://example.com/paperless/api/tags/?name__iexact=Tax%202024&page_size=1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &paperless{url: tt.base, cfg: &config.Config{APIPath: tt.apiPath}}
+			if got := p.apiURL(tt.path); got != tt.want {
+				t.Errorf("apiURL(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
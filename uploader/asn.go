@@ -0,0 +1,78 @@
+package uploader
+
+import (
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+
+	"paperlesslink/state"
+)
+
+// asnFromName applies -asn-regex to the file name and returns the archive
+// serial number in its capture group.
+func (u *Uploader) asnFromName(log *slog.Logger, filePath string) (int, bool) {
+	if u.asnRe == nil {
+		return 0, false
+	}
+	name := filepath.Base(filePath)
+	m := u.asnRe.FindStringSubmatch(name)
+	if m == nil {
+		log.Debug("ASN regex did not match file name", "file", name)
+		return 0, false
+	}
+	asn, err := strconv.Atoi(m[1])
+	if err != nil || asn <= 0 {
+		log.Warn("cannot parse archive serial number from file name", "file", name, "match", m[1])
+		return 0, false
+	}
+	return asn, true
+}
+
+// asnCounter hands out archive serial numbers from the counter in the state
+// file to concurrent uploads. A number is reserved for one upload at a time
+// and only committed to the state file once that upload succeeded; a number
+// whose upload failed goes to the next upload, so the sequence only has a
+// gap if PaperlessLink restarts in between. Its zero value is ready to use.
+type asnCounter struct {
+	mu       sync.Mutex
+	reserved map[int]bool
+	free     []int // released unused, below the next new number
+}
+
+// reserve returns the lowest free number, or else the next one from store,
+// never less than start.
+func (c *asnCounter) reserve(store *state.Store, start int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reserved == nil {
+		c.reserved = make(map[int]bool)
+	}
+	var asn int
+	if len(c.free) > 0 {
+		i := slices.Index(c.free, slices.Min(c.free))
+		asn = c.free[i]
+		c.free = slices.Delete(c.free, i, i+1)
+	} else {
+		asn = store.NextASN(start)
+		for c.reserved[asn] {
+			asn++
+		}
+	}
+	c.reserved[asn] = true
+	return asn
+}
+
+// release ends the reservation of asn. A used number is committed to store;
+// an unused one is handed out again.
+func (c *asnCounter) release(store *state.Store, asn int, used bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.reserved, asn)
+	if !used {
+		c.free = append(c.free, asn)
+		return nil
+	}
+	return store.CommitASN(asn)
+}
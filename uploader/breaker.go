@@ -0,0 +1,89 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned for uploads not attempted because the circuit
+// breaker of their Paperless instance is open.
+var errCircuitOpen = errors.New("paperless unreachable on repeated uploads, not trying until the circuit breaker cooldown has passed")
+
+// breaker stops uploads to a Paperless instance after threshold uploads in a
+// row failed because it was unreachable. While open, uploads fail at once;
+// after cooldown the next upload first probes the instance with a ping and
+// closes the breaker if it answers. A zero threshold disables it.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int       // consecutive unreachable failures
+	openUntil time.Time // zero while closed
+	probing   bool      // a probe ping is in flight
+}
+
+// admit reports whether an upload to p may go ahead, probing p once the
+// cooldown has passed. It returns an error wrapping errCircuitOpen if not.
+func (p *paperless) admit(ctx context.Context, log *slog.Logger) error {
+	b := &p.breaker
+	if b.threshold == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	if b.openUntil.IsZero() {
+		b.mu.Unlock()
+		return nil
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		b.mu.Unlock()
+		return errCircuitOpen
+	}
+	b.probing = true
+	b.mu.Unlock()
+
+	err := p.ping(ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err != nil {
+		if ctx.Err() == nil {
+			b.openUntil = time.Now().Add(b.cooldown)
+			log.Warn("paperless still unreachable, circuit breaker stays open", "target", p.name, "retry_in", b.cooldown, "error", err)
+		}
+		return fmt.Errorf("%w: %v", errCircuitOpen, err)
+	}
+	b.failures, b.openUntil = 0, time.Time{}
+	log.Info("paperless reachable again, circuit breaker closed", "target", p.name)
+	return nil
+}
+
+// record counts the outcome of an upload attempt to p, opening the breaker
+// once threshold uploads in a row found p unreachable. Any answer from
+// Paperless, even an error, resets the count.
+func (p *paperless) record(log *slog.Logger, err error) {
+	b := &p.breaker
+	if b.threshold == 0 || errors.Is(err, context.Canceled) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil || !unreachable(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold && b.openUntil.IsZero() {
+		b.openUntil = time.Now().Add(b.cooldown)
+		log.Warn("paperless unreachable, circuit breaker open: failing uploads at once",
+			"target", p.name,
+			"failures", b.failures,
+			"cooldown", b.cooldown,
+		)
+	}
+}
@@ -0,0 +1,91 @@
+package uploader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"paperlesslink/config"
+)
+
+// maxIdleConnsPerHost keeps a few connections to Paperless warm so bursts of
+// uploads reuse TCP and TLS sessions instead of re-handshaking every time.
+const maxIdleConnsPerHost = 4
+
+// newHTTPClient builds the client shared by every request to Paperless.
+func newHTTPClient(cfg *config.Config) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = max(maxIdleConnsPerHost, cfg.Concurrency)
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.DialContext = (&net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY apply unless -proxy is set.
+	transport.Proxy = http.ProxyFromEnvironment
+	proxyURL, err := cfg.ParseProxy()
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		transport.Proxy = http.ProxyURL(proxyURL)
+		slog.Info("using HTTP proxy", "proxy", proxyURL.Redacted())
+	}
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   cfg.HTTPTimeout,
+	}, nil
+}
+
+// newTLSConfig returns the TLS settings for talking to Paperless: the system
+// roots plus an optional private CA, an optional client certificate, and
+// optionally no verification at all.
+func newTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("read CA certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no PEM certificates found in " + cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+		slog.Info("trusting additional CA certificate", "file", cfg.CACert)
+	}
+
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		slog.Info("using client certificate for mutual TLS", "file", cfg.ClientCert)
+	}
+
+	if cfg.InsecureSkipVerify {
+		slog.Warn("TLS certificate verification is DISABLED; connections to Paperless can be intercepted")
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,56 @@
+package uploader
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"paperlesslink/config"
+)
+
+// TestProxy sends a request for a Paperless host that does not resolve
+// through -proxy, which must receive it with the absolute URL and the
+// credentials from the proxy URL.
+func TestProxy(t *testing.T) {
+	var got *http.Request
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("proxyuser", "secret")
+	client, err := newHTTPClient(&config.Config{
+		Proxy:       proxyURL.String(),
+		DialTimeout: time.Second,
+		HTTPTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.Get("http://paperless.invalid/api/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got == nil {
+		t.Fatal("request did not reach the proxy")
+	}
+	if got.Host != "paperless.invalid" || got.URL.String() != "http://paperless.invalid/api/" {
+		t.Errorf("proxy got host %q, URL %q; want the Paperless URL", got.Host, got.URL)
+	}
+	auth, ok := strings.CutPrefix(got.Header.Get("Proxy-Authorization"), "Basic ")
+	if !ok {
+		t.Fatalf("no basic Proxy-Authorization header, got %q", got.Header.Get("Proxy-Authorization"))
+	}
+	if creds, _ := base64.StdEncoding.DecodeString(auth); string(creds) != "proxyuser:secret" {
+		t.Errorf("proxy credentials %q, want proxyuser:secret", creds)
+	}
+}
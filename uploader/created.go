@@ -0,0 +1,57 @@
+package uploader
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// createdDate returns the document date to send as the created field, or the
+// zero time to let Paperless detect it. Sources are tried in order:
+//
+//  1. -date-regex: the first capture group of a match on the file name,
+//     parsed with -date-layout;
+//  2. -use-mtime: the file's modification time.
+func (u *Uploader) createdDate(log *slog.Logger, filePath string) time.Time {
+	if t, ok := u.dateFromName(log, filePath); ok {
+		return t
+	}
+	if u.cfg.UseMTime {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			log.Warn("cannot read modification time", "file", filePath, "error", err)
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// dateFromName applies -date-regex and -date-layout to the file name.
+func (u *Uploader) dateFromName(log *slog.Logger, filePath string) (time.Time, bool) {
+	if u.dateRe == nil {
+		return time.Time{}, false
+	}
+	name := filepath.Base(filePath)
+	m := u.dateRe.FindStringSubmatch(name)
+	if m == nil {
+		log.Debug("date regex did not match file name", "file", name)
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(u.cfg.DateLayout, m[1], time.Local)
+	if err != nil {
+		log.Warn("cannot parse date from file name", "file", name, "match", m[1], "layout", u.cfg.DateLayout, "error", err)
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// formatCreated renders t for the created form field: a plain date when t
+// has no time of day, otherwise a full timestamp with offset.
+func formatCreated(t time.Time) string {
+	if h, m, s := t.Clock(); h == 0 && m == 0 && s == 0 {
+		return t.Format(time.DateOnly)
+	}
+	return t.Format(time.RFC3339)
+}
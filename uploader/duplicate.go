@@ -0,0 +1,53 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"paperlesslink/config"
+)
+
+// isDuplicate reports whether err is Paperless refusing a document it already
+// has. Depending on the version this comes back as a 4xx response to
+// post_document or as the result of the failed consumption task, e.g.
+// "Not consuming x.pdf: It is a duplicate of y (#12)."
+func isDuplicate(err error) bool {
+	var httpErr *HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return httpErr.StatusCode < 500 && mentionsDuplicate(httpErr.Body)
+	case errors.Is(err, ErrConsumptionFailed):
+		return mentionsDuplicate(err.Error())
+	}
+	return false
+}
+
+func mentionsDuplicate(s string) bool {
+	return strings.Contains(strings.ToLower(s), "duplicate")
+}
+
+// handleDuplicate applies -on-duplicate to filePath, which Paperless rejected
+// as a duplicate with cause, noting what was done in res.Action. sum is its
+// SHA-256 when a state file is used.
+func (u *Uploader) handleDuplicate(log *slog.Logger, filePath, sum string, res *Result, cause error) error {
+	switch u.cfg.OnDuplicate {
+	case config.OnDuplicateSkip:
+		log.Info("paperless already has this document, skipping", "file", filePath)
+		u.recordUpload(log, filePath, sum)
+		return u.postUploadAction(log, filePath, res)
+
+	case config.OnDuplicateBackup:
+		log.Info("paperless already has this document, backing up", "file", filePath)
+		u.recordUpload(log, filePath, sum)
+		u.actionMu.Lock()
+		defer u.actionMu.Unlock()
+		if err := u.backup(log, filePath); err != nil {
+			return fmt.Errorf("backup duplicate: %w", err)
+		}
+		res.Action = string(config.AfterUploadBackup)
+		return nil
+	}
+	return u.quarantine(log, filePath, res, cause)
+}
@@ -0,0 +1,40 @@
+package uploader
+
+import (
+	"context"
+	"crypto/md5"
+	"net/url"
+	"strings"
+)
+
+// existingDocument returns the ID of a Paperless document with the same
+// content as filePath, or 0 if there is none. Paperless identifies documents
+// by the MD5 checksum of the original file, so that is what is compared.
+//
+// Checksums are unique in Paperless, so more than one match means the filter
+// was ignored, as django-filter does with parameters it does not know; that
+// must never read as "already there", or the file would be deleted unsent.
+func (p *paperless) existingDocument(ctx context.Context, filePath string) (int, error) {
+	sum, err := fileHash(filePath, md5.New())
+	if err != nil {
+		return 0, err
+	}
+	var docs listResponse[struct {
+		ID       int    `json:"id"`
+		Checksum string `json:"checksum"`
+	}]
+	query := url.Values{"checksum__iexact": {sum}, "page_size": {"1"}}
+	if err := p.getJSON(ctx, "/documents/", query, &docs); err != nil {
+		return 0, err
+	}
+	if docs.Count != 1 || len(docs.Results) != 1 {
+		return 0, nil
+	}
+	// Not every Paperless version returns the checksum; where it does, it
+	// must be ours.
+	doc := docs.Results[0]
+	if doc.Checksum != "" && !strings.EqualFold(doc.Checksum, sum) {
+		return 0, nil
+	}
+	return doc.ID, nil
+}
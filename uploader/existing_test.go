@@ -0,0 +1,87 @@
+package uploader
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"paperlesslink/config"
+)
+
+// TestExistingDocument runs the lookup against a fake Paperless holding
+// three documents, one of them with the content of the uploaded file.
+func TestExistingDocument(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scan.pdf")
+	writeFile(t, path, "%PDF-1.4 existing")
+	sum, err := fileHash(path, md5.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type doc struct {
+		ID       int    `json:"id"`
+		Checksum string `json:"checksum,omitempty"`
+	}
+	for _, tt := range []struct {
+		name string
+		docs []doc
+		// ignoreFilter answers like a Paperless that does not know the
+		// checksum filter: with every document.
+		ignoreFilter bool
+		// hideChecksum leaves the checksum out of the response, as older
+		// Paperless versions do.
+		hideChecksum bool
+		want         int
+	}{
+		{"match", []doc{{1, "aaa"}, {7, sum}, {9, "bbb"}}, false, false, 7},
+		{"match in upper case", []doc{{7, strings.ToUpper(sum)}}, false, false, 7},
+		{"match without checksum in the response", []doc{{1, "aaa"}, {7, sum}}, false, true, 7},
+		{"no match", []doc{{1, "aaa"}, {9, "bbb"}}, false, false, 0},
+		{"empty paperless", nil, false, false, 0},
+		{"filter ignored", []doc{{1, "aaa"}, {7, sum}, {9, "bbb"}}, true, false, 0},
+		{"filter ignored, single document", []doc{{1, "aaa"}}, true, false, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/documents/" {
+					http.NotFound(w, r)
+					return
+				}
+				var found []doc
+				want := r.URL.Query().Get("checksum__iexact")
+				for _, d := range tt.docs {
+					if tt.ignoreFilter || (want != "" && strings.EqualFold(d.Checksum, want)) {
+						if tt.hideChecksum {
+							d.Checksum = ""
+						}
+						found = append(found, d)
+					}
+				}
+				results := found
+				if r.URL.Query().Get("page_size") == "1" && len(results) > 1 {
+					results = results[:1]
+				}
+				if results == nil {
+					results = []doc{}
+				}
+				json.NewEncoder(w).Encode(map[string]any{"count": len(found), "results": results})
+			}))
+			defer srv.Close()
+
+			p := &paperless{url: srv.URL, token: "token", cfg: &config.Config{APIPath: "/api"}, client: srv.Client()}
+			got, err := p.existingDocument(context.Background(), path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("existingDocument = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package uploader
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upload metrics, published through expvar, e.g. on the -pprof-addr listener
+// under /debug/vars.
+var (
+	uploadBytes    = expvar.NewInt("upload_bytes_total")
+	uploadDuration = newHistogram("upload_duration_seconds", []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120})
+)
+
+// histogram is an expvar.Var counting observations into cumulative buckets,
+// as a Prometheus histogram does: each bucket counts the observations less
+// than or equal to its upper bound.
+type histogram struct {
+	bounds []float64
+
+	mu     sync.Mutex
+	counts []int64 // one per bound, plus +Inf
+	sum    float64
+}
+
+// newHistogram publishes a histogram with the given ascending upper bounds.
+func newHistogram(name string, bounds []float64) *histogram {
+	h := &histogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+	expvar.Publish(name, h)
+	return h
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.counts[len(h.bounds)]++
+	h.sum += v
+}
+
+// String implements expvar.Var, rendering
+// {"buckets": {"0.1": n, …, "+Inf": n}, "count": n, "sum": s}.
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var b strings.Builder
+	b.WriteString(`{"buckets": {`)
+	for i, bound := range h.bounds {
+		fmt.Fprintf(&b, "%q: %d, ", strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	count := h.counts[len(h.bounds)]
+	fmt.Fprintf(&b, `"+Inf": %d}, "count": %d, "sum": %s}`, count, count, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	return b.String()
+}
+
+// countingWriter counts the bytes written through it; n may be read while
+// another goroutine writes.
+type countingWriter struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// throughput returns n bytes over d in bytes per second, or 0 for a zero d.
+func throughput(n int64, d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(float64(n) / d.Seconds())
+}
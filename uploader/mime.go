@@ -0,0 +1,204 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is how much of a file http.DetectContentType looks at.
+const sniffLen = 512
+
+// builtinMIME lists types for formats Paperless accepts that Go's own table
+// lacks. They are used ahead of the system types, which come from
+// /etc/mime.types or the Windows registry and may be missing or odd (such as
+// application/vnd.ms-excel for .csv), so uploads look the same everywhere.
+var builtinMIME = map[string]string{
+	"tif":  "image/tiff",
+	"tiff": "image/tiff",
+	"heic": "image/heic",
+	"heif": "image/heif",
+	"bmp":  "image/bmp",
+	"txt":  "text/plain; charset=utf-8",
+	"csv":  "text/csv; charset=utf-8",
+	"md":   "text/markdown; charset=utf-8",
+	"eml":  "message/rfc822",
+	"rtf":  "application/rtf",
+	"doc":  "application/msword",
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"xls":  "application/vnd.ms-excel",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"ppt":  "application/vnd.ms-powerpoint",
+	"pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	"odt":  "application/vnd.oasis.opendocument.text",
+	"ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	"odp":  "application/vnd.oasis.opendocument.presentation",
+}
+
+// mimeType returns the MIME type for the file extension of filePath: the
+// -mime-override entry, the built-in type or the system type (same
+// behaviour as curl -F @file), in that order, defaulting to
+// application/octet-stream.
+func (u *Uploader) mimeType(filePath string) string {
+	if t := u.typeByExt(filePath); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// typeByExt is mimeType without the default; it returns "" for unknown
+// extensions.
+func (u *Uploader) typeByExt(filePath string) string {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if t, ok := u.mimeTypes[strings.TrimPrefix(ext, ".")]; ok {
+		return t
+	}
+	if t, ok := builtinMIME[strings.TrimPrefix(ext, ".")]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// compressible reports whether files of type t usually shrink under gzip.
+// Formats that are compressed already, such as PDF, JPEG, PNG and the
+// ZIP-based office formats, do not and only cost CPU time.
+func compressible(t string) bool {
+	t = mediaType(t)
+	switch {
+	case strings.HasPrefix(t, "text/"), strings.HasPrefix(t, "message/"),
+		strings.HasSuffix(t, "+xml"), strings.HasSuffix(t, "/json"), strings.HasSuffix(t, "/xml"):
+		return true
+	}
+	switch t {
+	case "image/tiff", "image/bmp", "application/rtf", "application/msword",
+		"application/vnd.ms-excel", "application/vnd.ms-powerpoint":
+		return true
+	}
+	return false
+}
+
+// checkContent sniffs the content of filePath and applies -verify-mime and
+// -allowed-mime to it, returning a permanent error for a file that fails
+// either.
+func (u *Uploader) checkContent(log *slog.Logger, filePath string) error {
+	sniffed, err := sniffMIME(filePath)
+	if err != nil {
+		return err
+	}
+	byExt := mediaType(u.typeByExt(filePath))
+	log.Debug("sniffed content type", "file", filePath, "sniffed", sniffed, "by_ext", byExt)
+	if u.cfg.VerifyMIME {
+		if err := verifyMIME(sniffed, byExt); err != nil {
+			return err
+		}
+	}
+	if len(u.allowedMIME) > 0 {
+		t := contentType(sniffed, byExt)
+		if !allowedMIME(u.allowedMIME, t) {
+			return &permanentError{fmt.Errorf("content type %s is not allowed by -allowed-mime", t)}
+		}
+	}
+	return nil
+}
+
+// sniffMIME returns the media type http.DetectContentType finds in the
+// first bytes of filePath.
+func sniffMIME(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("open file: %w", err)
+	}
+	defer f.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+	return mediaType(http.DetectContentType(head[:n])), nil
+}
+
+// verifyMIME returns a permanent error if the sniffed content type
+// contradicts byExt, the type of the file's extension, e.g. an HTML error
+// page saved as .pdf. Files whose extension or content is not recognised
+// pass.
+func verifyMIME(sniffed, byExt string) error {
+	if byExt == "" || compatibleMIME(byExt, sniffed) {
+		return nil
+	}
+	return &permanentError{fmt.Errorf("content looks like %s, not %s as the extension says", sniffed, byExt)}
+}
+
+// signatureTypes are types http.DetectContentType recognises by their
+// signature; a file without it is not of the type, whatever its extension.
+var signatureTypes = map[string]bool{
+	"application/pdf": true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/bmp":       true,
+}
+
+// contentType returns the type of a file's content for -allowed-mime: the
+// sniffed type or, where the sniffer only tells the kind of data (unknown
+// binary, plain text, ZIP or XML), byExt if the content is compatible with
+// it, so a TIFF or an Office document counts as what its extension says.
+func contentType(sniffed, byExt string) string {
+	if byExt == "" || byExt == sniffed || signatureTypes[byExt] {
+		return sniffed
+	}
+	switch sniffed {
+	case "application/octet-stream", "text/plain", "application/zip", "text/xml":
+		if compatibleMIME(byExt, sniffed) {
+			return byExt
+		}
+	}
+	return sniffed
+}
+
+// allowedMIME reports whether t matches one of types, where "image/*"
+// matches every image type.
+func allowedMIME(types []string, t string) bool {
+	for _, a := range types {
+		if a == t || strings.HasSuffix(a, "/*") && strings.HasPrefix(t, strings.TrimSuffix(a, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// compatibleMIME reports whether content sniffed as sniffed can be a file of
+// type byExt. The sniffer only knows a few dozen signatures, so unknown
+// binary data, plain text for text-based formats and ZIP for ZIP-based
+// formats (Office documents, EPUB) are accepted.
+func compatibleMIME(byExt, sniffed string) bool {
+	switch {
+	case byExt == sniffed, sniffed == "application/octet-stream":
+		return true
+	case sniffed == "text/plain":
+		return strings.HasPrefix(byExt, "text/") || strings.HasPrefix(byExt, "message/") ||
+			strings.HasSuffix(byExt, "+xml") || strings.HasSuffix(byExt, "/json") ||
+			byExt == "application/rtf"
+	case sniffed == "application/zip":
+		return strings.HasSuffix(byExt, "+zip") || strings.Contains(byExt, "openxmlformats") ||
+			strings.Contains(byExt, "opendocument")
+	case sniffed == "text/xml":
+		return strings.HasSuffix(byExt, "+xml") || byExt == "application/xml"
+	}
+	return false
+}
+
+// mediaType strips parameters such as "; charset=utf-8" from a MIME type.
+func mediaType(t string) string {
+	mt, _, err := mime.ParseMediaType(t)
+	if err != nil {
+		return t
+	}
+	return mt
+}
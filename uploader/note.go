@@ -0,0 +1,47 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// NoteData is the data available to -note-template: the TitleData fields
+// plus those only known once the document is consumed.
+type NoteData struct {
+	TitleData
+	File       string // original file name
+	Title      string // title the document was uploaded with
+	DocumentID int    // Paperless document ID
+}
+
+// addNote renders tmpl for the consumed document id, whose title was rendered
+// from data, and adds the result as a note. It is best effort: a failure is
+// logged and does not fail the upload.
+func (p *paperless) addNote(ctx context.Context, log *slog.Logger, tmpl *template.Template, filePath string, data TitleData, title string, id int) {
+	nd := NoteData{
+		TitleData:  data,
+		File:       filepath.Base(filePath),
+		Title:      title,
+		DocumentID: id,
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, nd); err != nil {
+		log.Warn("could not render note template", "file", filePath, "document_id", id, "error", err)
+		return
+	}
+	note := strings.TrimSpace(b.String())
+	if note == "" {
+		return
+	}
+	var out json.RawMessage
+	if err := p.postJSON(ctx, fmt.Sprintf("/documents/%d/notes/", id), map[string]string{"note": note}, &out); err != nil {
+		log.Warn("could not add note to document", "file", filePath, "document_id", id, "error", err)
+		return
+	}
+	log.Info("note added to document", "file", filePath, "document_id", id)
+}
@@ -0,0 +1,183 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"paperlesslink/config"
+)
+
+// maxRetryDelay caps the exponential backoff so a long outage does not push
+// individual waits into hours.
+const maxRetryDelay = 5 * time.Minute
+
+// HTTPError is returned when Paperless answers with a non-2xx status.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+
+	// RetryAfter is the wait the server asked for in a Retry-After header,
+	// typically with 429 or 503, or zero if it sent none.
+	RetryAfter time.Duration
+
+	// Problems are the validation errors of a 400 response, one readable
+	// sentence per field error, such as "tag 42 does not exist"; empty if
+	// the body held none.
+	Problems []string
+}
+
+// newHTTPError builds the error for resp, whose body has been read into body.
+func newHTTPError(resp *http.Response, body []byte) *HTTPError {
+	e := &HTTPError{
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+	if resp.StatusCode == http.StatusBadRequest {
+		e.Problems = parseProblems(body)
+	}
+	return e
+}
+
+// parseRetryAfter reads a Retry-After value, either delay-seconds or an
+// HTTP-date, relative to now. Missing, malformed and past values give zero.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
+
+func (e *HTTPError) Error() string {
+	if len(e.Problems) > 0 {
+		return fmt.Sprintf("paperless rejected the request (HTTP %d): %s", e.StatusCode, strings.Join(e.Problems, "; "))
+	}
+	return fmt.Sprintf("paperless returned HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// permanentError marks a failure that no amount of retrying will fix, such as
+// a tag name that does not exist in Paperless.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// retryable reports whether err is worth another attempt: network errors and
+// 5xx/429 responses are; other HTTP errors (4xx), permanent errors, local
+// failures such as an unreadable source file, and cancellation are not.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var permErr *permanentError
+	if errors.As(err, &permErr) {
+		return false
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500 || httpErr.StatusCode == http.StatusTooManyRequests
+	}
+	return networkError(err)
+}
+
+// networkError reports whether err came from the connection to Paperless
+// rather than from this side. A file error wins even when the HTTP client
+// returned it, since the upload body is read from the file while it is
+// sent.
+func networkError(err error) bool {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// unreachable reports whether err means Paperless could not be reached at
+// all, as opposed to rejecting the request: network errors and the 502, 503
+// and 504 answers of a proxy in front of a Paperless that is down.
+func unreachable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var permErr *permanentError
+	if errors.As(err, &permErr) {
+		return false
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		switch httpErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return networkError(err)
+}
+
+// withRetry calls fn until it succeeds, returns a non-retryable error,
+// cfg.MaxRetries additional attempts have been made, or ctx is cancelled.
+func withRetry(ctx context.Context, log *slog.Logger, cfg *config.Config, filePath string, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		if !retryable(err) {
+			return err
+		}
+		if attempt >= cfg.MaxRetries {
+			break
+		}
+		delay := backoff(cfg.RetryBaseDelay, attempt)
+		// A server that says when to come back is taken at its word, up to
+		// maxRetryDelay, rather than guessed at with the backoff.
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+			delay = min(httpErr.RetryAfter, maxRetryDelay)
+		}
+		log.Warn("upload attempt failed, retrying",
+			"file", filePath,
+			"attempt", attempt+1,
+			"max_retries", cfg.MaxRetries,
+			"delay", delay,
+			"error", err,
+		)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("giving up after %d retries: %w", cfg.MaxRetries, err)
+}
+
+// backoff returns base·2^attempt (capped at maxRetryDelay) with jitter in
+// the upper half of the interval.
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base << attempt
+	if d <= 0 || d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
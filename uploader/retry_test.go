@@ -0,0 +1,39 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	pathErr := &fs.PathError{Op: "open", Path: "/scans/a.pdf", Err: fs.ErrNotExist}
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	for _, tt := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"dial error", &url.Error{Op: "Post", URL: "http://p/api/", Err: dialErr}, true},
+		{"truncated response", fmt.Errorf("decode response: %w", io.ErrUnexpectedEOF), true},
+		{"500", &HTTPError{StatusCode: 500}, true},
+		{"429", &HTTPError{StatusCode: 429}, true},
+		{"400", &HTTPError{StatusCode: 400}, false},
+		{"open source file", fmt.Errorf("open file: %w", pathErr), false},
+		{"source file failing while sent", &url.Error{Op: "Post", URL: "http://p/api/", Err: pathErr}, false},
+		{"permanent", &permanentError{errors.New("tag not found")}, false},
+		{"cancelled", fmt.Errorf("http post: %w", context.Canceled), false},
+		{"other local error", errors.New("uuid copy: no space left"), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
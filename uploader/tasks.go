@@ -0,0 +1,68 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+// taskPollInterval is how often the consumption task status is queried.
+const taskPollInterval = 2 * time.Second
+
+// Paperless consumption task states (Celery states).
+const (
+	taskSuccess = "SUCCESS"
+	taskFailure = "FAILURE"
+)
+
+// task is the subset of a Paperless /api/tasks/ entry PaperlessLink uses.
+type task struct {
+	TaskID          string `json:"task_id"`
+	Status          string `json:"status"`
+	Result          string `json:"result"`
+	RelatedDocument string `json:"related_document"`
+}
+
+// ErrConsumptionFailed is wrapped by the error returned when Paperless marks
+// the consumption task of an upload as failed.
+var ErrConsumptionFailed = errors.New("paperless failed to consume document")
+
+// waitForTask polls the consumption task taskID until it succeeds, fails,
+// cfg.ConsumptionTimeout elapses, or ctx is cancelled. Transient errors while
+// polling are logged and the poll continues.
+func (p *paperless) waitForTask(ctx context.Context, log *slog.Logger, taskID string) (task, error) {
+	deadline := time.Now().Add(p.cfg.ConsumptionTimeout)
+	for {
+		var tasks []task
+		err := p.getJSON(ctx, "/tasks/", url.Values{"task_id": {taskID}}, &tasks)
+		switch {
+		case ctx.Err() != nil:
+			return task{}, ctx.Err()
+		case err != nil:
+			log.Warn("could not query consumption task", "task_id", taskID, "error", err)
+		case len(tasks) == 0:
+			log.Debug("consumption task not registered yet", "task_id", taskID)
+		default:
+			t := tasks[0]
+			switch t.Status {
+			case taskSuccess:
+				return t, nil
+			case taskFailure:
+				return t, fmt.Errorf("%w: %s", ErrConsumptionFailed, t.Result)
+			}
+			log.Debug("waiting for consumption", "task_id", taskID, "status", t.Status)
+		}
+
+		if time.Now().After(deadline) {
+			return task{}, fmt.Errorf("consumption task %s not finished after %s", taskID, p.cfg.ConsumptionTimeout)
+		}
+		select {
+		case <-time.After(taskPollInterval):
+		case <-ctx.Done():
+			return task{}, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package uploader
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// tempPrefix starts the name of every temporary directory PaperlessLink
+// creates, so leftovers can be found again.
+const tempPrefix = "paperlesslink-"
+
+// staleTempAge is how old a leftover temporary directory must be before the
+// startup sweep removes it; younger ones may belong to another instance
+// that is still uploading.
+const staleTempAge = time.Hour
+
+// uuidCopy copies filePath to a UUID-named file, keeping the extension, in a
+// new private directory below os.TempDir. When the temp directory is on the
+// same filesystem, the "copy" is a hard link to filePath and costs no I/O;
+// otherwise, as across devices or where hard links are unsupported, the
+// content is copied. remove deletes the copy and its directory, leaving
+// filePath itself alone; it is safe to call when the copy failed half way.
+func uuidCopy(log *slog.Logger, filePath string) (path string, remove func(), err error) {
+	dir, err := os.MkdirTemp("", tempPrefix+"*")
+	if err != nil {
+		return "", nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	remove = func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Warn("could not remove temp uuid file", "dir", dir, "error", err)
+		}
+	}
+	path = filepath.Join(dir, uuid.New().String()+filepath.Ext(filePath))
+	linkErr := os.Link(filePath, path)
+	if linkErr == nil {
+		log.Debug("hard-linked file to uuid name", "file", filePath, "path", path)
+		return path, remove, nil
+	}
+	log.Debug("cannot hard-link to temp dir, copying", "file", filePath, "error", linkErr)
+	if err := copyFile(filePath, path); err != nil {
+		remove()
+		return "", nil, err
+	}
+	return path, remove, nil
+}
+
+// sweepTemp removes temporary directories left behind by an earlier run that
+// crashed or was killed during an upload.
+func sweepTemp() {
+	entries, err := os.ReadDir(os.TempDir())
+	if err != nil {
+		slog.Warn("cannot look for stale temp files", "dir", os.TempDir(), "error", err)
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), tempPrefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < staleTempAge {
+			continue
+		}
+		path := filepath.Join(os.TempDir(), e.Name())
+		if err := os.RemoveAll(path); err != nil {
+			slog.Warn("cannot remove stale temp files", "dir", path, "error", err)
+			continue
+		}
+		slog.Info("removed stale temp files from an earlier run", "dir", path)
+	}
+}
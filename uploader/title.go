@@ -0,0 +1,93 @@
+package uploader
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+
+	"paperlesslink/transform"
+)
+
+// TitleData is the data available to -title-template.
+type TitleData struct {
+	Stem    string    // file name without extension, after -title-transform
+	Ext     string    // lower-case extension without leading dot
+	Dir     string    // name of the directory containing the file
+	Now     time.Time // upload time
+	ModTime time.Time // file modification time
+}
+
+// title derives the document title for filePath, described by data, from
+// tmpl. Without a template it is data.Stem; a template that renders to an
+// empty string also falls back to it. The result is cleaned up with
+// cleanTitle.
+func (u *Uploader) title(log *slog.Logger, filePath string, data TitleData, tmpl *template.Template) (string, error) {
+	if tmpl == nil {
+		return data.Stem, nil
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("render title template: %w", err)
+	}
+	title := cleanTitle(b.String())
+	if title == "" {
+		log.Warn("title template rendered empty, using file name", "file", filePath)
+		return data.Stem, nil
+	}
+	return title, nil
+}
+
+// titleData returns the TitleData for filePath, with tf applied to the stem.
+// A transform that leaves nothing of the stem is ignored.
+func titleData(filePath string, tf transform.Pipeline) TitleData {
+	name := filepath.Base(filePath)
+	raw := strings.TrimSuffix(name, filepath.Ext(name))
+	stem := cleanTitle(raw)
+	if s := cleanTitle(tf.Apply(raw)); s != "" {
+		stem = s
+	}
+	data := TitleData{
+		Stem: stem,
+		Ext:  strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), "."),
+		Dir:  filepath.Base(filepath.Dir(filePath)),
+		Now:  time.Now(),
+	}
+	if info, err := os.Stat(filePath); err == nil {
+		data.ModTime = info.ModTime()
+	}
+	return data
+}
+
+// cleanTitle makes s safe to send as a title: invalid UTF-8 becomes U+FFFD,
+// the text is normalised to NFC so names from macOS (which decomposes
+// accents) match those typed elsewhere, path separators become '-', and
+// runs of control characters and other whitespace, such as a newline in a
+// file name, collapse into a single space.
+func cleanTitle(s string) string {
+	s = norm.NFC.String(strings.ToValidUTF8(s, "\uFFFD"))
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		switch {
+		case unicode.IsControl(r) || unicode.IsSpace(r):
+			space = true
+			continue
+		case r == '/' || r == '\\':
+			r = '-'
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package uploader
+
+import "testing"
+
+func TestCleanTitle(t *testing.T) {
+	for _, tt := range []struct {
+		name, in, want string
+	}{
+		{"plain", "Invoice 2024", "Invoice 2024"},
+		{"emoji", "Receipt 🧾 coffee ☕", "Receipt 🧾 coffee ☕"},
+		{"emoji sequence", "Family 👨‍👩‍👧 photo", "Family 👨‍👩‍👧 photo"},
+		{"flag", "Trip 🇦🇹", "Trip 🇦🇹"},
+		{"precomposed accents", "Café Müller", "Café Müller"},
+		{"decomposed accents", "Cafe\u0301 Mu\u0308ller", "Café Müller"},
+		{"newline", "line one\nline two", "line one line two"},
+		{"crlf", "line one\r\nline two", "line one line two"},
+		{"leading and trailing newlines", "\n\ntitle\n", "title"},
+		{"tabs and spaces", "a\t \tb", "a b"},
+		{"other control characters", "a\x00b\x1bc\x7fd", "a b c d"},
+		{"path separators", "2024/03\\scan", "2024-03-scan"},
+		{"invalid utf-8", "bad\xffbyte", "bad�byte"},
+		{"only whitespace", " \n\t", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cleanTitle(tt.in); got != tt.want {
+				t.Errorf("cleanTitle(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
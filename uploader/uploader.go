@@ -5,175 +5,1140 @@ package uploader
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log/slog"
-	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
 
 	"paperlesslink/config"
+	"paperlesslink/manifest"
+	"paperlesslink/notify"
+	"paperlesslink/state"
+	"paperlesslink/transform"
+	"paperlesslink/trash"
 )
 
-// Upload uploads filePath to Paperless-ngx using the provided config and
-// performs the configured post-upload action.
-func Upload(cfg *config.Config, filePath string) error {
-	slog.Info("starting upload", "file", filePath)
+// Uploader sends files to Paperless-ngx. It holds the HTTP client so that
+// connections are pooled across uploads; create one with New and reuse it.
+type Uploader struct {
+	cfg *config.Config
+
+	// targets holds the Paperless instances by target name; "" is the
+	// default instance given by -url.
+	targets map[string]*paperless
+
+	// store remembers checksums of uploaded files; nil without -state-file.
+	store *state.Store
+
+	// live holds the settings Reload can change while uploads are running.
+	live atomic.Pointer[settings]
+
+	// mimeTypes holds the -mime-override types by extension.
+	mimeTypes map[string]string
+
+	// allowedMIME holds the -allowed-mime types; empty allows all.
+	allowedMIME []string
+
+	// dateRe extracts the created date from file names; nil disables it.
+	dateRe *regexp.Regexp
+
+	// asnRe extracts the archive serial number from file names; nil
+	// disables it.
+	asnRe *regexp.Regexp
+
+	// asns hands out archive serial numbers from the -asn-start counter.
+	asns asnCounter
+
+	// notifier reports finished uploads; nil without -notify-url.
+	notifier *notify.Notifier
+
+	// manifest records every processed file; nil without -manifest.
+	manifest *manifest.Manifest
+
+	// actionMu serialises post-upload actions so concurrent uploads never
+	// race on the same backup destination.
+	actionMu sync.Mutex
+}
+
+// settings are the options that can be changed at runtime with Reload.
+type settings struct {
+	meta config.Metadata
+
+	// consumptionTag is added to every document on top of meta and any
+	// rule; empty is none.
+	consumptionTag string
+
+	// titleTmpl renders document titles; nil uses the file name stem.
+	titleTmpl *template.Template
+
+	// titleTransform cleans up the file name stem.
+	titleTransform transform.Pipeline
+
+	// noteTmpl renders the note added to consumed documents; nil adds none.
+	noteTmpl *template.Template
+}
+
+// documentMeta holds the metadata form fields sent alongside the document.
+// Zero IDs mean "not set" and are omitted from the form.
+type documentMeta struct {
+	FileName      string // file name of the document part
+	MIMEType      string // content type of the document part
+	Title         string
+	Created       time.Time // zero = let Paperless detect it
+	Tags          []int
+	Correspondent int
+	DocumentType  int
+	StoragePath   int
+	ASN           int // archive serial number; 0 = not set
+
+	// CustomFields maps custom field IDs to their values; nil values set
+	// the field without a value.
+	CustomFields map[int]*string
+
+	Owner       int          // user ID; 0 = the token's user
+	Permissions *permissions // nil = Paperless defaults
+}
+
+// permissions is the set_permissions form field: the user and group IDs that
+// may view or change the document.
+type permissions struct {
+	View   permissionSet `json:"view"`
+	Change permissionSet `json:"change"`
+}
+
+type permissionSet struct {
+	Users  []int `json:"users"`
+	Groups []int `json:"groups"`
+}
+
+// New returns an Uploader for cfg, setting up the HTTP client and loading the
+// state file if one is set.
+func New(cfg *config.Config) (*Uploader, error) {
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	u := &Uploader{
+		cfg: cfg,
+		targets: map[string]*paperless{
+			"": {url: cfg.PaperlessURL, token: cfg.Token, cfg: cfg, client: client},
+		},
+	}
+	for name, t := range cfg.Targets {
+		u.targets[name] = &paperless{name: name, url: t.URL, token: t.Token, cfg: cfg, client: client}
+	}
+	for _, p := range u.targets {
+		p.breaker = breaker{threshold: cfg.CircuitThreshold, cooldown: cfg.CircuitCooldown}
+	}
+	if err := u.Reload(cfg); err != nil {
+		return nil, err
+	}
+	if u.mimeTypes, err = cfg.ParseMIMEOverrides(); err != nil {
+		return nil, err
+	}
+	if u.allowedMIME, err = cfg.ParseAllowedMIME(); err != nil {
+		return nil, err
+	}
+	if u.dateRe, err = cfg.ParseDateRegex(); err != nil {
+		return nil, err
+	}
+	if u.asnRe, err = cfg.ParseASNRegex(); err != nil {
+		return nil, err
+	}
+	if cfg.NotifyURL != "" && !cfg.DryRun {
+		u.notifier = notify.New(cfg.NotifyURL, cfg.UserAgent)
+	}
+	if cfg.Manifest != "" && !cfg.DryRun {
+		if u.manifest, err = manifest.Open(cfg.Manifest); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RenameToUUID && !cfg.DryRun {
+		sweepTemp()
+	}
+	if cfg.StateFile != "" {
+		store, err := state.Open(cfg.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		u.store = store
+	}
+	return u, nil
+}
+
+// Reload applies the runtime-changeable settings of cfg, the global metadata,
+// the title transform and the title and note templates, to uploads started
+// from now on. Every other field of cfg is ignored.
+func (u *Uploader) Reload(cfg *config.Config) error {
+	tmpl, err := cfg.ParseTitleTemplate()
+	if err != nil {
+		return err
+	}
+	tf, err := transform.Parse(cfg.TitleTransform)
+	if err != nil {
+		return err
+	}
+	noteTmpl, err := cfg.ParseNoteTemplate()
+	if err != nil {
+		return err
+	}
+	u.live.Store(&settings{
+		meta:           cfg.DefaultMetadata(),
+		consumptionTag: cfg.ConsumptionTag,
+		titleTmpl:      tmpl,
+		titleTransform: tf,
+		noteTmpl:       noteTmpl,
+	})
+	return nil
+}
+
+// Upload uploads filePath, found in the watch directory watchDir, to
+// Paperless-ngx and performs the configured post-upload action. Cancelling
+// ctx aborts the upload; the file is then left where it is. The Result is
+// filled in as far as the upload got, also when an error is returned.
+// Every log line of the upload carries its Result.ID as upload_id.
+func (u *Uploader) Upload(ctx context.Context, filePath, watchDir string) (Result, error) {
+	res := Result{ID: newUploadID()}
+	log := slog.With("upload_id", res.ID)
+	err := u.upload(ctx, log, filePath, watchDir, &res)
+	if u.cfg.OfflineQueue && !res.Queued && !errors.Is(err, context.Canceled) {
+		if err := u.store.Dequeue(filePath); err != nil {
+			log.Warn("could not remove file from offline queue in state file", "file", filePath, "error", err)
+		}
+	}
+	u.notify(filePath, res, err)
+	u.appendManifest(log, filePath, res, err)
+	return res, err
+}
+
+// Queued returns the files in the offline queue that can be uploaded again,
+// pinging each Paperless instance files are queued for. waiting counts the
+// files left queued for instances that are still unreachable, and err is
+// the first ping failure. Entries whose file has gone away are dropped.
+func (u *Uploader) Queued(ctx context.Context) (ready []state.QueuedFile, waiting int, err error) {
+	if !u.cfg.OfflineQueue {
+		return nil, 0, nil
+	}
+	checked := make(map[*paperless]error)
+	for _, q := range u.store.Queued() {
+		if _, err := os.Stat(q.Path); errors.Is(err, os.ErrNotExist) {
+			slog.Info("queued file no longer exists, dropping it from the offline queue", "file", q.Path)
+			if err := u.store.Dequeue(q.Path); err != nil {
+				slog.Warn("could not remove file from offline queue in state file", "file", q.Path, "error", err)
+			}
+			continue
+		}
+		p := u.target(u.cfg.RuleFor(q.Dir, filepath.Dir(q.Path)))
+		pingErr, ok := checked[p]
+		if !ok {
+			pingErr = p.ping(ctx)
+			if pingErr != nil && p.name != "" {
+				pingErr = fmt.Errorf("target %s: %w", p.name, pingErr)
+			}
+			checked[p] = pingErr
+			if pingErr != nil && err == nil {
+				err = pingErr
+			}
+		}
+		if pingErr != nil {
+			waiting++
+			continue
+		}
+		ready = append(ready, q)
+	}
+	return ready, waiting, err
+}
+
+// newUploadID returns a short random ID telling apart the log lines of
+// concurrent uploads: the first block of a UUID, such as "9b2f4c1e".
+func newUploadID() string {
+	id, _, _ := strings.Cut(uuid.New().String(), "-")
+	return id
+}
+
+// Result describes an upload.
+type Result struct {
+	// ID correlates the log lines of the upload.
+	ID string
+
+	Title string
+
+	// Size is the file size in bytes.
+	Size int64
+
+	// TaskID is the UUID of the consumption task Paperless queued.
+	TaskID string
+
+	// DocumentID is the ID of the created document; it is only known with
+	// -confirm-consumption.
+	DocumentID int
+
+	// ASN is the archive serial number sent with the document, or 0.
+	ASN int
+
+	// Skipped is set when nothing was uploaded because Paperless already
+	// has the content.
+	Skipped bool
+
+	// Queued is set when Paperless was unreachable and the file was put in
+	// the offline queue instead.
+	Queued bool
+
+	// SHA256 is the checksum of the file; it is only computed with
+	// -state-file or -manifest.
+	SHA256 string
+
+	// Action is what was done with the file afterwards: the -after-upload
+	// action, or ActionErrorDir. It is empty if the file was left in place
+	// for another attempt.
+	Action string
+}
+
+// ActionErrorDir is the Result.Action of a file moved to -error-dir.
+const ActionErrorDir = "error-dir"
+
+// upload implements Upload, filling in res as it goes.
+func (u *Uploader) upload(ctx context.Context, log *slog.Logger, filePath, watchDir string, res *Result) error {
+	cfg := u.cfg
+	log.Info("starting upload", "file", filePath)
+	if info, err := os.Stat(filePath); err == nil {
+		res.Size = info.Size()
+	}
+
+	rule := cfg.RuleFor(watchDir, filepath.Dir(filePath))
+	p := u.target(rule)
+
+	// Skip content that was already uploaded, but still finish the
+	// post-upload action that a crash may have interrupted. A backup by
+	// copy leaves the original behind on purpose; copying it again on every
+	// restart would only pile up numbered duplicates.
+	var sum string
+	if u.store != nil || u.manifest != nil {
+		var err error
+		if sum, err = fileSHA256(filePath); err != nil {
+			return fmt.Errorf("checksum: %w", err)
+		}
+		res.SHA256 = sum
+		if u.store != nil && u.store.Has(sum) {
+			log.Info("file already uploaded, skipping", "file", filePath, "sha256", sum)
+			res.Skipped = true
+			if cfg.AfterUpload == config.AfterUploadBackup && cfg.BackupMode == config.BackupModeCopy {
+				return nil
+			}
+			return u.postUploadAction(log, filePath, res)
+		}
+	}
+
+	// Content imported into Paperless some other way counts as uploaded. A
+	// failed lookup is not fatal; Paperless rejects real duplicates anyway.
+	if cfg.SkipExisting {
+		id, err := p.existingDocument(ctx, filePath)
+		switch {
+		case err != nil:
+			log.Warn("cannot check paperless for an existing copy, uploading", "file", filePath, "error", err)
+		case id != 0:
+			log.Info("file already in paperless, skipping", "file", filePath, "document_id", id)
+			u.recordUpload(log, filePath, sum)
+			res.Skipped = true
+			return u.postUploadAction(log, filePath, res)
+		}
+	}
+
+	// Title and date are derived from the original file, never the UUID copy.
+	live := u.live.Load()
+	data := titleData(filePath, live.titleTransform)
+	title, err := u.title(log, filePath, data, live.titleTmpl)
+	if err != nil {
+		return err
+	}
+	res.Title = title
+
+	created := u.createdDate(log, filePath)
+
+	md := live.meta
+	if rule != nil {
+		log.Debug("applying directory rule", "file", filePath, "rule", rule.Dir, "target", rule.Target)
+		md = md.Merge(rule.Metadata)
+	}
+	if t := live.consumptionTag; t != "" && !slices.Contains(md.Tags, t) {
+		md.Tags = append(slices.Clip(md.Tags), t)
+	}
+
+	if cfg.VerifyMIME || len(u.allowedMIME) > 0 {
+		if err := u.checkContent(log, filePath); err != nil {
+			return u.quarantine(log, filePath, res, err)
+		}
+	}
+
+	// Names are logged unresolved; resolving could create missing objects.
+	if cfg.DryRun {
+		log.Info("dry run: would upload",
+			"file", filePath,
+			"endpoint", p.apiURL("/documents/post_document/"),
+			"title", title,
+			"created", created,
+			"mime", u.mimeType(filePath),
+			"rename_uuid", cfg.RenameToUUID,
+			"tags", md.Tags,
+			"correspondent", md.Correspondent,
+			"document_type", md.DocumentType,
+			"storage_path", md.StoragePath,
+			"custom_fields", md.CustomFields,
+			"owner", md.Owner,
+			"view_users", md.ViewUsers,
+			"view_groups", md.ViewGroups,
+			"edit_users", md.EditUsers,
+			"edit_groups", md.EditGroups,
+		)
+		return u.postUploadAction(log, filePath, res)
+	}
+
+	// Do not wait through the retries again while Paperless is known to be
+	// down; the queue is drained once Ping succeeds.
+	if cfg.OfflineQueue && p.offline.Load() {
+		res.Queued = true
+		return u.enqueue(log, p, filePath, watchDir, errOffline)
+	}
+
+	// Nor while the circuit breaker is open. The file is left in place.
+	if err := p.admit(ctx, log); err != nil {
+		if cfg.OfflineQueue {
+			res.Queued = true
+			return u.enqueue(log, p, filePath, watchDir, err)
+		}
+		return err
+	}
 
 	// Resolve the actual file to upload (may be a UUID-named temp copy).
 	uploadPath := filePath
 	originalName := filepath.Base(filePath)
 
 	if cfg.RenameToUUID {
-		ext := filepath.Ext(filePath)
-		uuidName := uuid.New().String() + ext
-		uploadPath = filepath.Join(os.TempDir(), uuidName)
-		if err := copyFile(filePath, uploadPath); err != nil {
+		path, remove, err := uuidCopy(log, filePath)
+		if err != nil {
 			return fmt.Errorf("uuid copy: %w", err)
 		}
-		slog.Info("file copied with UUID name for upload",
-			"uuid_name", uuidName,
+		defer remove()
+		uploadPath = path
+		log.Info("file copied with UUID name for upload",
+			"uuid_name", filepath.Base(uploadPath),
 			"original", originalName,
 		)
+	}
+
+	// A number in the file name wins over the counter. A counter number is
+	// reserved for this upload only, and committed once it succeeded, so
+	// other uploads go ahead meanwhile and failed ones leave no gaps.
+	asn, asnFromName := u.asnFromName(log, filePath)
+	asnUsed := false
+	if !asnFromName && cfg.ASNStart > 0 {
+		asn = u.asns.reserve(u.store, cfg.ASNStart)
 		defer func() {
-			if err := os.Remove(uploadPath); err != nil && !os.IsNotExist(err) {
-				slog.Warn("could not remove temp uuid file", "path", uploadPath, "error", err)
+			if !asnUsed {
+				_ = u.asns.release(u.store, asn, false)
 			}
 		}()
 	}
 
-	// Title = original filename stem (without extension).
-	stem := strings.TrimSuffix(originalName, filepath.Ext(originalName))
+	var taskID string
+	err = withRetry(ctx, log, cfg, filePath, func() error {
+		meta, err := p.buildMeta(ctx, log, title, md)
+		if err != nil {
+			return err
+		}
+		meta.Created = created
+		meta.ASN = asn
+		meta.MIMEType = u.mimeType(filePath)
+		meta.FileName = filepath.Base(uploadPath)
+		if cfg.PreserveFilename {
+			meta.FileName = originalName
+		}
+		taskID, err = p.postDocument(ctx, log, uploadPath, meta)
+		return err
+	})
+	p.record(log, err)
+	if err != nil {
+		err = fmt.Errorf("upload failed: %w", err)
+		if isDuplicate(err) {
+			res.Skipped = true
+			return u.handleDuplicate(log, filePath, sum, res, err)
+		}
+		if cfg.OfflineQueue && unreachable(err) {
+			res.Queued = true
+			return u.enqueue(log, p, filePath, watchDir, err)
+		}
+		return u.quarantine(log, filePath, res, err)
+	}
+
+	res.TaskID, res.ASN = taskID, asn
+	log.Info("upload successful", "file", filePath, "title", title, "task_id", taskID)
+
+	// post_document only queues the file; with -confirm-consumption the
+	// original is kept until Paperless has actually ingested it.
+	if cfg.ConfirmConsumption {
+		if taskID == "" {
+			return errors.New("cannot confirm consumption: paperless returned no task id")
+		}
+		t, err := p.waitForTask(ctx, log, taskID)
+		if err != nil {
+			err = fmt.Errorf("consumption: %w", err)
+			if isDuplicate(err) {
+				res.Skipped = true
+				return u.handleDuplicate(log, filePath, sum, res, err)
+			}
+			return u.quarantine(log, filePath, res, err)
+		}
+		if id, err := strconv.Atoi(t.RelatedDocument); err == nil {
+			res.DocumentID = id
+		}
+		log.Info("document consumed", "file", filePath, "task_id", taskID, "document_id", res.DocumentID)
+		if live.noteTmpl != nil && res.DocumentID != 0 {
+			p.addNote(ctx, log, live.noteTmpl, filePath, data, title, res.DocumentID)
+		}
+	}
+
+	if asn != 0 && !asnFromName {
+		asnUsed = true
+		if err := u.asns.release(u.store, asn, true); err != nil {
+			log.Warn("could not record archive serial number in state file", "file", filePath, "asn", asn, "error", err)
+		}
+		log.Info("archive serial number assigned", "file", filePath, "asn", asn)
+	}
+
+	u.recordUpload(log, filePath, sum)
+	return u.postUploadAction(log, filePath, res)
+}
+
+// target returns the Paperless instance for files matched by rule, which may
+// be nil.
+func (u *Uploader) target(rule *config.Rule) *paperless {
+	if rule != nil && rule.Target != "" {
+		return u.targets[rule.Target]
+	}
+	return u.targets[""]
+}
+
+// errOffline is the reason given for files queued without an upload attempt.
+var errOffline = errors.New("paperless was unreachable on an earlier upload")
 
-	if err := postDocument(cfg, uploadPath, stem); err != nil {
-		return fmt.Errorf("upload failed: %w", err)
+// enqueue puts filePath in the offline queue after an upload failed with
+// cause because p was unreachable. The file stays where it is.
+func (u *Uploader) enqueue(log *slog.Logger, p *paperless, filePath, watchDir string, cause error) error {
+	p.offline.Store(true)
+	if err := u.store.Enqueue(filePath, watchDir); err != nil {
+		return fmt.Errorf("%w (adding to offline queue also failed: %v)", cause, err)
 	}
+	log.Warn("paperless unreachable, file queued for later upload", "file", filePath, "error", cause)
+	return nil
+}
 
-	slog.Info("upload successful", "file", filePath, "title", stem)
-	return postUploadAction(cfg, filePath)
+// recordUpload remembers sum, the checksum of filePath, in the state file.
+func (u *Uploader) recordUpload(log *slog.Logger, filePath, sum string) {
+	if u.store == nil || u.cfg.DryRun {
+		return
+	}
+	if err := u.store.Add(sum); err != nil {
+		log.Warn("could not record upload in state file", "file", filePath, "error", err)
+	}
 }
 
-// postDocument performs the multipart POST to Paperless-ngx.
-func postDocument(cfg *config.Config, filePath, title string) error {
+// notify reports a finished upload to -notify-url. Cancelled uploads are not
+// reported; the file is retried on the next run.
+func (u *Uploader) notify(filePath string, res Result, err error) {
+	if u.notifier == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	msg := notify.Message{
+		File:       filePath,
+		Title:      res.Title,
+		Status:     notify.StatusSuccess,
+		TaskID:     res.TaskID,
+		DocumentID: res.DocumentID,
+	}
+	switch {
+	case err != nil:
+		msg.Status, msg.Error = notify.StatusFailure, err.Error()
+	case res.Skipped || res.Queued || u.cfg.NotifyOn == config.NotifyOnFailure:
+		return
+	}
+	u.notifier.Send(msg)
+}
+
+// appendManifest records a finished upload in -manifest. Like notify it
+// leaves out cancelled uploads, which are retried on the next run.
+func (u *Uploader) appendManifest(log *slog.Logger, filePath string, res Result, err error) {
+	if u.manifest == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	e := manifest.Entry{
+		Time:       time.Now().UTC(),
+		UploadID:   res.ID,
+		File:       filePath,
+		SHA256:     res.SHA256,
+		Size:       res.Size,
+		Status:     manifest.StatusUploaded,
+		Title:      res.Title,
+		TaskID:     res.TaskID,
+		DocumentID: res.DocumentID,
+		Action:     res.Action,
+	}
+	switch {
+	case err != nil:
+		e.Status, e.Error = manifest.StatusFailed, err.Error()
+	case res.Queued:
+		e.Status = manifest.StatusQueued
+	case res.Skipped:
+		e.Status = manifest.StatusSkipped
+	}
+	if err := u.manifest.Append(e); err != nil {
+		log.Warn("could not write to manifest", "file", filePath, "manifest", u.cfg.Manifest, "error", err)
+	}
+}
+
+// Close waits for pending notifications to be delivered and closes the
+// manifest.
+func (u *Uploader) Close() {
+	if u.notifier != nil {
+		u.notifier.Wait()
+	}
+	if u.manifest != nil {
+		if err := u.manifest.Close(); err != nil {
+			slog.Warn("could not close manifest", "manifest", u.cfg.Manifest, "error", err)
+		}
+	}
+}
+
+// buildMeta resolves md into the form fields for an upload titled title.
+func (p *paperless) buildMeta(ctx context.Context, log *slog.Logger, title string, md config.Metadata) (documentMeta, error) {
+	meta := documentMeta{Title: title}
+	tags, err := p.resolveIDs(ctx, log, "/tags/", md.Tags)
+	if err != nil {
+		return meta, fmt.Errorf("resolve tags: %w", err)
+	}
+	meta.Tags = tags
+	if meta.Correspondent, err = p.resolveOptionalID(ctx, log, "/correspondents/", md.Correspondent); err != nil {
+		return meta, fmt.Errorf("resolve correspondent: %w", err)
+	}
+	if meta.DocumentType, err = p.resolveOptionalID(ctx, log, "/document_types/", md.DocumentType); err != nil {
+		return meta, fmt.Errorf("resolve document type: %w", err)
+	}
+	if meta.StoragePath, err = p.resolveOptionalID(ctx, log, "/storage_paths/", md.StoragePath); err != nil {
+		return meta, fmt.Errorf("resolve storage path: %w", err)
+	}
+	for _, e := range md.CustomFields {
+		ref, value, hasValue, err := config.ParseCustomField(e)
+		if err != nil {
+			return meta, &permanentError{fmt.Errorf("custom field %q: %w", e, err)}
+		}
+		id, err := p.resolveID(ctx, log, "/custom_fields/", ref)
+		if err != nil {
+			return meta, fmt.Errorf("resolve custom field: %w", err)
+		}
+		if meta.CustomFields == nil {
+			meta.CustomFields = make(map[int]*string)
+		}
+		meta.CustomFields[id] = nil
+		if hasValue {
+			meta.CustomFields[id] = &value
+		}
+	}
+	if meta.Owner, err = p.resolveOptionalID(ctx, log, usersEndpoint, md.Owner); err != nil {
+		return meta, fmt.Errorf("resolve owner: %w", err)
+	}
+	if len(md.ViewUsers)+len(md.ViewGroups)+len(md.EditUsers)+len(md.EditGroups) > 0 {
+		perms := &permissions{}
+		for _, l := range []struct {
+			dst      *[]int
+			endpoint string
+			refs     []string
+		}{
+			{&perms.View.Users, usersEndpoint, md.ViewUsers},
+			{&perms.View.Groups, "/groups/", md.ViewGroups},
+			{&perms.Change.Users, usersEndpoint, md.EditUsers},
+			{&perms.Change.Groups, "/groups/", md.EditGroups},
+		} {
+			if *l.dst, err = p.resolveIDs(ctx, log, l.endpoint, l.refs); err != nil {
+				return meta, fmt.Errorf("resolve permissions: %w", err)
+			}
+		}
+		meta.Permissions = perms
+	}
+	return meta, nil
+}
+
+// postDocument performs the multipart POST to Paperless-ngx and returns the
+// UUID of the consumption task Paperless queued for it.
+func (p *paperless) postDocument(ctx context.Context, log *slog.Logger, filePath string, meta documentMeta) (string, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("open file: %w", err)
+		return "", fmt.Errorf("open file: %w", err)
 	}
 	defer f.Close()
 
-	// Build the multipart body in memory so we can set Content-Length.
-	var body bytes.Buffer
-	mw := multipart.NewWriter(&body)
+	// Stream the multipart body from disk through a pipe so memory use stays
+	// flat regardless of file size. The request is sent chunked. With
+	// -buffer-upload the whole body is built in memory first instead, so it
+	// goes out with a Content-Length for proxies that refuse chunked bodies.
+	var (
+		buf  bytes.Buffer
+		pr   *io.PipeReader
+		pw   *io.PipeWriter
+		sink io.Writer = &buf
+	)
+	if !p.cfg.BufferUpload {
+		pr, pw = io.Pipe()
+		defer pr.Close()
+		sink = pw
+	}
+	sent := &countingWriter{w: sink}
+	sink = sent
 
-	// --- document field -------------------------------------------------------
-	// Use the correct MIME type for the file extension (same behaviour as curl -F @file).
-	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(filePath)))
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+	// With -gzip-upload, formats that gain from it are compressed on the way.
+	var zw *gzip.Writer
+	if p.cfg.GzipUpload && compressible(meta.MIMEType) {
+		zw = gzip.NewWriter(sink)
+		sink = zw
+	}
+	mw := multipart.NewWriter(sink)
+	write := func() error {
+		if err := writeForm(log, mw, f, meta); err != nil {
+			return err
+		}
+		if zw != nil {
+			return zw.Close()
+		}
+		return nil
 	}
+
+	var body io.Reader = pr
+	if p.cfg.BufferUpload {
+		if err := write(); err != nil {
+			return "", err
+		}
+		body = bytes.NewReader(buf.Bytes())
+	} else {
+		go func() {
+			pw.CloseWithError(write())
+		}()
+	}
+
+	req, err := p.newRequest(ctx, http.MethodPost, "/documents/post_document/", body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if zw != nil {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	log.Debug("posting to paperless",
+		"endpoint", req.URL.String(),
+		"title", meta.Title,
+		"created", meta.Created,
+		"tags", meta.Tags,
+		"correspondent", meta.Correspondent,
+		"document_type", meta.DocumentType,
+		"storage_path", meta.StoragePath,
+		"asn", meta.ASN,
+		"custom_fields", len(meta.CustomFields),
+		"owner", meta.Owner,
+		"content_length", req.ContentLength,
+		"gzip", zw != nil,
+	)
+
+	// The time until Paperless has answered is the transfer plus storing
+	// the file; consumption happens afterwards, in waitForTask.
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("http post: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	elapsed := time.Since(start)
+	log.Debug("paperless response", "status", resp.StatusCode, "body", string(respBody), "bytes", sent.n.Load())
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", newHTTPError(resp, respBody)
+	}
+	n := sent.n.Load()
+	uploadBytes.Add(n)
+	uploadDuration.observe(elapsed.Seconds())
+	log.Info("document sent",
+		"file", filePath,
+		"bytes", n,
+		"duration", elapsed.Round(time.Millisecond),
+		"bytes_per_sec", throughput(n, elapsed),
+	)
+
+	taskID, err := parseTaskID(respBody)
+	if err != nil {
+		log.Warn("could not parse task id from paperless response", "body", string(respBody), "error", err)
+	}
+	return taskID, nil
+}
+
+// parseTaskID extracts the consumption task UUID from a post_document
+// response. Paperless answers with the UUID as a bare JSON string; an object
+// with a task_id field is accepted too.
+func parseTaskID(body []byte) (string, error) {
+	var taskID string
+	if err := json.Unmarshal(body, &taskID); err == nil {
+		return taskID, nil
+	}
+	var obj struct {
+		TaskID string `json:"task_id"`
+	}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return "", err
+	}
+	if obj.TaskID == "" {
+		return "", errors.New("no task_id in response")
+	}
+	return obj.TaskID, nil
+}
+
+// writeForm writes the document and metadata fields into mw and closes it.
+// It usually runs in its own goroutine, feeding the request body pipe; with
+// -buffer-upload it is called before the request, into an in-memory buffer.
+func writeForm(log *slog.Logger, mw *multipart.Writer, f io.Reader, meta documentMeta) error {
+	// --- document field -------------------------------------------------------
+	mimeType := meta.MIMEType
 	h := make(textproto.MIMEHeader)
 	h.Set("Content-Disposition",
-		fmt.Sprintf(`form-data; name="document"; filename="%s"`, filepath.Base(filePath)))
+		fmt.Sprintf(`form-data; name="document"; filename="%s"`, quoteFileName(meta.FileName)))
 	h.Set("Content-Type", mimeType)
 	part, err := mw.CreatePart(h)
 	if err != nil {
 		return fmt.Errorf("create form file part: %w", err)
 	}
-	slog.Debug("document part mime type", "mime", mimeType)
+	log.Debug("document part mime type", "mime", mimeType)
 	n, err := io.Copy(part, f)
 	if err != nil {
 		return fmt.Errorf("write file content to form: %w", err)
 	}
-	slog.Debug("file content written to form", "bytes", n)
+	log.Debug("file content written to form", "bytes", n)
 
 	// --- title field ----------------------------------------------------------
-	if err := mw.WriteField("title", title); err != nil {
+	if err := mw.WriteField("title", meta.Title); err != nil {
 		return fmt.Errorf("write title field: %w", err)
 	}
 
-	// Close MUST be called before reading body.Body (writes boundary epilogue).
-	contentType := mw.FormDataContentType()
-	if err := mw.Close(); err != nil {
-		return fmt.Errorf("close multipart writer: %w", err)
+	// --- created field --------------------------------------------------------
+	if !meta.Created.IsZero() {
+		if err := mw.WriteField("created", formatCreated(meta.Created)); err != nil {
+			return fmt.Errorf("write created field: %w", err)
+		}
 	}
 
-	endpoint := strings.TrimRight(cfg.PaperlessURL, "/") + "/api/documents/post_document/"
-	slog.Debug("posting to paperless", "endpoint", endpoint, "title", title, "body_bytes", body.Len())
+	// --- tags field (repeated once per tag) -----------------------------------
+	for _, id := range meta.Tags {
+		if err := mw.WriteField("tags", strconv.Itoa(id)); err != nil {
+			return fmt.Errorf("write tags field: %w", err)
+		}
+	}
 
-	req, err := http.NewRequest(http.MethodPost, endpoint, &body)
-	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+	// --- optional single-valued ID and number fields -------------------------
+	for _, field := range []struct {
+		name string
+		id   int
+	}{
+		{"correspondent", meta.Correspondent},
+		{"document_type", meta.DocumentType},
+		{"storage_path", meta.StoragePath},
+		{"archive_serial_number", meta.ASN},
+		{"owner", meta.Owner},
+	} {
+		if field.id == 0 {
+			continue
+		}
+		if err := mw.WriteField(field.name, strconv.Itoa(field.id)); err != nil {
+			return fmt.Errorf("write %s field: %w", field.name, err)
+		}
 	}
-	req.Header.Set("Authorization", "Token "+cfg.Token)
-	req.Header.Set("Content-Type", contentType)
-	req.Header.Set("User-Agent", "curl/7.81.0")
 
-	client := &http.Client{Timeout: 120 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("http post: %w", err)
+	// --- custom fields, as a JSON object of field ID → value ---------------
+	if len(meta.CustomFields) > 0 {
+		raw, err := json.Marshal(meta.CustomFields)
+		if err != nil {
+			return fmt.Errorf("encode custom_fields field: %w", err)
+		}
+		if err := mw.WriteField("custom_fields", string(raw)); err != nil {
+			return fmt.Errorf("write custom_fields field: %w", err)
+		}
 	}
-	defer resp.Body.Close()
 
-	respBody, _ := io.ReadAll(resp.Body)
-	slog.Info("paperless response", "status", resp.StatusCode, "body", string(respBody))
+	// --- set_permissions, as a JSON object --------------------------------
+	if meta.Permissions != nil {
+		raw, err := json.Marshal(meta.Permissions)
+		if err != nil {
+			return fmt.Errorf("encode set_permissions field: %w", err)
+		}
+		if err := mw.WriteField("set_permissions", string(raw)); err != nil {
+			return fmt.Errorf("write set_permissions field: %w", err)
+		}
+	}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("paperless returned HTTP %d: %s", resp.StatusCode, string(respBody))
+	// Close writes the boundary epilogue.
+	if err := mw.Close(); err != nil {
+		return fmt.Errorf("close multipart writer: %w", err)
 	}
 	return nil
 }
 
-// postUploadAction deletes or backs up the original file after a successful upload.
-func postUploadAction(cfg *config.Config, filePath string) error {
+// postUploadAction deletes, backs up or keeps the original file after a
+// successful upload, noting what it did in res.Action.
+func (u *Uploader) postUploadAction(log *slog.Logger, filePath string, res *Result) error {
+	u.actionMu.Lock()
+	defer u.actionMu.Unlock()
+
+	cfg := u.cfg
+	if cfg.DryRun {
+		log.Info("dry run: would run post-upload action", "file", filePath, "action", cfg.AfterUpload, "backup_dir", cfg.BackupDir)
+		return nil
+	}
 	switch cfg.AfterUpload {
 	case config.AfterUploadDelete:
 		if err := os.Remove(filePath); err != nil {
 			return fmt.Errorf("delete after upload: %w", err)
 		}
-		slog.Info("file deleted after upload", "file", filePath)
+		log.Info("file deleted after upload", "file", filePath)
+
+	case config.AfterUploadTrash:
+		if err := trash.Move(filePath); err != nil {
+			return fmt.Errorf("move to trash after upload: %w", err)
+		}
+		log.Info("file moved to trash after upload", "file", filePath)
 
 	case config.AfterUploadBackup:
-		dst := filepath.Join(cfg.BackupDir, filepath.Base(filePath))
-		if err := moveFile(filePath, dst); err != nil {
+		if err := u.backup(log, filePath); err != nil {
 			return fmt.Errorf("backup after upload: %w", err)
 		}
-		slog.Info("file moved to backup", "src", filePath, "dst", dst)
+
+	case config.AfterUploadKeep:
+		log.Debug("file kept in place after upload", "file", filePath)
 	}
+	res.Action = string(cfg.AfterUpload)
 	return nil
 }
 
-// moveFile moves src to dst, falling back to copy+delete for cross-device moves.
-func moveFile(src, dst string) error {
-	if err := os.Rename(src, dst); err == nil {
+// backup moves or, with -backup-mode=copy, copies filePath into the backup
+// directory under a free name. The caller holds actionMu.
+func (u *Uploader) backup(log *slog.Logger, filePath string) error {
+	dir, err := u.backupDir(filePath)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, filepath.Base(filePath))
+	if u.cfg.BackupMode == config.BackupModeCopy {
+		dst, err := toFreePath(path, func(dst string) error { return copyFile(filePath, dst) })
+		if err != nil {
+			return err
+		}
+		log.Info("file copied to backup", "src", filePath, "dst", dst)
 		return nil
 	}
-	if err := copyFile(src, dst); err != nil {
+	dst, err := toFreePath(path, func(dst string) error { return moveFile(filePath, dst) })
+	if err != nil {
+		return err
+	}
+	log.Info("file moved to backup", "src", filePath, "dst", dst)
+	return nil
+}
+
+// backupDir returns the directory filePath is backed up into, creating the
+// YYYY/MM subdirectories selected by -backup-subdirs as needed.
+func (u *Uploader) backupDir(filePath string) (string, error) {
+	var t time.Time
+	switch u.cfg.BackupSubdirs {
+	case config.BackupSubdirsUploadDate:
+		t = time.Now()
+	case config.BackupSubdirsModTime:
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return "", err
+		}
+		t = info.ModTime()
+	default:
+		return u.cfg.BackupDir, nil
+	}
+	dir := filepath.Join(u.cfg.BackupDir, t.Format("2006"), t.Format("01"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// freePath returns path, or "<stem> (N)<ext>" with the lowest N >= 2 that does
+// not exist yet, so an earlier file of the same name is never overwritten.
+// Callers hold actionMu, so no other upload takes the name meanwhile; see
+// toFreePath for other writers.
+func freePath(path string) (string, error) {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+	candidate := path
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(candidate); errors.Is(err, os.ErrNotExist) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+	}
+}
+
+// toFreePath calls place with freePath(path) and returns the path used. place
+// must fail with os.ErrExist rather than replace an existing file, as moveFile
+// and copyFile do; when another process took the name since freePath looked,
+// the next free name is tried.
+func toFreePath(path string, place func(dst string) error) (string, error) {
+	var prev string
+	for {
+		dst, err := freePath(path)
+		if err != nil {
+			return "", err
+		}
+		err = place(dst)
+		// The same name again means it is not free after all, only
+		// invisible to freePath; give up rather than loop.
+		if !errors.Is(err, os.ErrExist) || dst == prev {
+			return dst, err
+		}
+		prev = dst
+	}
+}
+
+// quarantine moves a file whose upload failed for good into cfg.ErrorDir,
+// next to a "<name>.error.txt" sidecar describing the failure, so it is not
+// picked up again, and sets res.Action. It returns cause, annotated if the
+// move itself failed. Without an error directory the file is left where it is.
+func (u *Uploader) quarantine(log *slog.Logger, filePath string, res *Result, cause error) error {
+	// A cancelled upload says nothing about the file; retry it next run.
+	if u.cfg.ErrorDir == "" || errors.Is(cause, context.Canceled) {
+		return cause
+	}
+	if u.cfg.DryRun {
+		log.Info("dry run: would move to error dir", "file", filePath, "error_dir", u.cfg.ErrorDir)
+		return cause
+	}
+	u.actionMu.Lock()
+	defer u.actionMu.Unlock()
+
+	dst, err := toFreePath(filepath.Join(u.cfg.ErrorDir, filepath.Base(filePath)), func(dst string) error {
+		return moveFile(filePath, dst)
+	})
+	if err != nil {
+		return fmt.Errorf("%w (moving to error dir also failed: %v)", cause, err)
+	}
+
+	// Prefer the raw Paperless response; it usually explains the rejection.
+	detail := cause.Error()
+	var httpErr *HTTPError
+	if errors.As(cause, &httpErr) {
+		detail = fmt.Sprintf("HTTP %d\n\n%s", httpErr.StatusCode, httpErr.Body)
+	}
+	if err := os.WriteFile(dst+".error.txt", []byte(detail+"\n"), 0o644); err != nil {
+		log.Warn("could not write error sidecar", "file", dst, "error", err)
+	}
+
+	log.Warn("file moved to error dir", "src", filePath, "dst", dst)
+	res.Action = ActionErrorDir
+	return cause
+}
+
+// quoteFileName escapes name for the quoted filename parameter of a
+// Content-Disposition header, as mime/multipart does: backslashes and double
+// quotes are escaped, and control characters such as CR and LF, which could
+// end the header and inject new ones, are dropped.
+func quoteFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name)
+}
+
+// moveFile moves src to dst, failing with os.ErrExist instead of replacing a
+// file at dst, even one created there a moment ago: unlike a rename, a hard
+// link never overwrites. Where src cannot be linked, as across devices, it is
+// copied into a newly created dst instead. Either way src is then removed.
+func moveFile(src, dst string) error {
+	err := os.Link(src, dst)
+	if errors.Is(err, os.ErrExist) {
 		return err
 	}
+	if err != nil {
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	}
 	return os.Remove(src)
 }
 
-// copyFile copies src to dst.
-func copyFile(src, dst string) error {
+// fileSHA256 returns the hex-encoded SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	return fileHash(path, sha256.New())
+}
+
+// fileHash returns the hex-encoded digest h computes over the file at path.
+func fileHash(path string, h hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyFile copies src to dst, which must not exist yet; an existing file is
+// never truncated. A partial copy is removed on failure.
+func copyFile(src, dst string) (err error) {
 	in, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer in.Close()
 
-	out, err := os.Create(dst)
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
 
 	if _, err := io.Copy(out, in); err != nil {
 		return err
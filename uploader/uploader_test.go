@@ -0,0 +1,241 @@
+package uploader
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"paperlesslink/config"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wantContent fails the test unless the file at path holds content.
+func wantContent(t *testing.T, path, content string) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != content {
+		t.Errorf("%s holds %q, want %q", path, b, content)
+	}
+}
+
+func TestBackupSameName(t *testing.T) {
+	for _, mode := range []config.BackupMode{config.BackupModeMove, config.BackupModeCopy} {
+		t.Run(string(mode), func(t *testing.T) {
+			src, backup := t.TempDir(), t.TempDir()
+			u := &Uploader{cfg: &config.Config{BackupDir: backup, BackupMode: mode}}
+			for _, sub := range []string{"a", "b"} {
+				dir := filepath.Join(src, sub)
+				if err := os.Mkdir(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				writeFile(t, filepath.Join(dir, "scan.pdf"), sub)
+				if err := u.backup(slog.Default(), filepath.Join(dir, "scan.pdf")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			wantContent(t, filepath.Join(backup, "scan.pdf"), "a")
+			wantContent(t, filepath.Join(backup, "scan (2).pdf"), "b")
+		})
+	}
+}
+
+func TestMoveFileNeverReplaces(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src.pdf"), filepath.Join(dir, "dst.pdf")
+	writeFile(t, src, "new")
+	writeFile(t, dst, "old")
+
+	if err := moveFile(src, dst); !os.IsExist(err) {
+		t.Fatalf("moveFile onto an existing file: got %v, want an ErrExist error", err)
+	}
+	wantContent(t, src, "new")
+	wantContent(t, dst, "old")
+}
+
+// TestToFreePathRace has another writer take the free name between freePath
+// and the move; the move must go to the next name instead of replacing it.
+func TestToFreePathRace(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.pdf")
+	writeFile(t, src, "ours")
+	path := filepath.Join(dir, "backup", "scan.pdf")
+	if err := os.Mkdir(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	raced := false
+	dst, err := toFreePath(path, func(dst string) error {
+		if !raced {
+			raced = true
+			writeFile(t, dst, "theirs")
+		}
+		return moveFile(src, dst)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "backup", "scan (2).pdf"); dst != want {
+		t.Errorf("moved to %s, want %s", dst, want)
+	}
+	wantContent(t, path, "theirs")
+	wantContent(t, dst, "ours")
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source still there after move: %v", err)
+	}
+}
+
+// documentPart is the document field of an upload seen by testPaperless.
+type documentPart struct {
+	fileName    string // as parsed from Content-Disposition
+	disposition string // the raw header
+	header      textproto.MIMEHeader
+}
+
+// testPaperless starts a fake Paperless that accepts post_document and
+// returns a config for it and the document parts it received, in order.
+func testPaperless(t *testing.T) (*config.Config, *[]documentPart) {
+	t.Helper()
+	var parts []documentPart
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/documents/post_document/" {
+			http.NotFound(w, r)
+			return
+		}
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FormName() == "document" {
+				parts = append(parts, documentPart{
+					fileName:    part.FileName(),
+					disposition: part.Header.Get("Content-Disposition"),
+					header:      part.Header,
+				})
+			}
+		}
+		w.Write([]byte(`"4a9a1a56-7b8e-4a4f-9c1e-0d1e2f3a4b5c"`))
+	}))
+	t.Cleanup(srv.Close)
+	return &config.Config{
+		PaperlessURL: srv.URL,
+		Token:        "token",
+		APIPath:      "/api",
+		AfterUpload:  config.AfterUploadKeep,
+		DialTimeout:  time.Second,
+		HTTPTimeout:  5 * time.Second,
+		UserAgent:    "paperlesslink/test",
+	}, &parts
+}
+
+// upload uploads a file named name with cfg and returns the document part
+// Paperless received.
+func upload(t *testing.T, cfg *config.Config, parts *[]documentPart, name string) documentPart {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, name)
+	writeFile(t, path, "%PDF-1.4 test")
+	u, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	if _, err := u.Upload(context.Background(), path, dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(*parts) != 1 {
+		t.Fatalf("paperless received %d documents, want 1", len(*parts))
+	}
+	return (*parts)[0]
+}
+
+func TestPreserveFilename(t *testing.T) {
+	uuidName := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\.pdf$`)
+	for _, tt := range []struct {
+		name         string
+		renameUUID   bool
+		preserve     bool
+		wantOriginal bool
+	}{
+		{"plain", false, false, true},
+		{"uuid", true, false, false},
+		{"uuid preserving the name", true, true, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, parts := testPaperless(t)
+			cfg.RenameToUUID, cfg.PreserveFilename = tt.renameUUID, tt.preserve
+			got := upload(t, cfg, parts, "Rechnung März.pdf").fileName
+			switch {
+			case tt.wantOriginal && got != "Rechnung März.pdf":
+				t.Errorf("sent file name %q, want the original name", got)
+			case !tt.wantOriginal && !uuidName.MatchString(got):
+				t.Errorf("sent file name %q, want the UUID name of the temp copy", got)
+			}
+		})
+	}
+}
+
+func TestQuoteFileName(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"scan.pdf", "scan.pdf"},
+		{`say "cheese".pdf`, `say \"cheese\".pdf`},
+		{`back\slash.pdf`, `back\\slash.pdf`},
+		{"two\nlines.pdf", "twolines.pdf"},
+		{"evil\r\nX-Injected: 1.pdf", "evilX-Injected: 1.pdf"},
+		{"tab\tand\x00nul.pdf", "tabandnul.pdf"},
+	} {
+		if got := quoteFileName(tt.in); got != tt.want {
+			t.Errorf("quoteFileName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestContentDispositionFileName uploads files whose names hold quotes and
+// line breaks: Paperless must get the name back intact, minus the line
+// breaks, and no header may be injected into the document part.
+func TestContentDispositionFileName(t *testing.T) {
+	for _, tt := range []struct {
+		name, file, want string
+	}{
+		{"quotes", `say "cheese".pdf`, `say "cheese".pdf`},
+		{"newline", "two\nlines.pdf", "twolines.pdf"},
+		{"header injection", "evil\r\nX-Injected: 1\r\n\r\n.pdf", "evilX-Injected: 1.pdf"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, parts := testPaperless(t)
+			got := upload(t, cfg, parts, tt.file)
+			if got.fileName != tt.want {
+				t.Errorf("file name %q, want %q (header %q)", got.fileName, tt.want, got.disposition)
+			}
+			keys := slices.Sorted(maps.Keys(got.header))
+			if !slices.Equal(keys, []string{"Content-Disposition", "Content-Type"}) {
+				t.Errorf("document part headers %q, want only Content-Disposition and Content-Type", keys)
+			}
+		})
+	}
+}
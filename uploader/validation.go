@@ -0,0 +1,79 @@
+package uploader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// fieldNames are the singular names of the post_document fields, as used in
+// problem descriptions.
+var fieldNames = map[string]string{
+	"tags":                  "tag",
+	"correspondent":         "correspondent",
+	"document_type":         "document type",
+	"storage_path":          "storage path",
+	"archive_serial_number": "archive serial number",
+	"custom_fields":         "custom field",
+	"owner":                 "owner",
+	"set_permissions":       "permissions",
+}
+
+// invalidPK matches Django REST framework's message for an ID that does not
+// refer to an existing object, e.g. `Invalid pk "42" - object does not exist.`
+var invalidPK = regexp.MustCompile(`^Invalid pk "([^"]*)" - object does not exist\.?$`)
+
+// parseProblems extracts the validation errors from a Django REST framework
+// error body, {"field": ["message", …], …}, as sorted, readable sentences
+// such as "tag 42 does not exist". It returns nil for any other body.
+func parseProblems(body []byte) []string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil
+	}
+	var problems []string
+	for field, raw := range fields {
+		for _, msg := range fieldMessages(raw) {
+			problems = append(problems, describeProblem(field, msg))
+		}
+	}
+	slices.Sort(problems)
+	return problems
+}
+
+// fieldMessages returns the messages of one field: a single string, a list
+// of strings, or for nested errors the compacted JSON.
+func fieldMessages(raw json.RawMessage) []string {
+	var msg string
+	if err := json.Unmarshal(raw, &msg); err == nil {
+		return []string{msg}
+	}
+	var msgs []string
+	if err := json.Unmarshal(raw, &msgs); err == nil {
+		return msgs
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, raw); err != nil {
+		return nil
+	}
+	return []string{b.String()}
+}
+
+// describeProblem turns the message msg about field into a sentence.
+func describeProblem(field, msg string) string {
+	msg = strings.TrimSpace(msg)
+	if field == "detail" || field == "non_field_errors" {
+		return msg
+	}
+	name, ok := fieldNames[field]
+	if !ok {
+		name = strings.ReplaceAll(field, "_", " ")
+	}
+	if m := invalidPK.FindStringSubmatch(msg); m != nil {
+		return fmt.Sprintf("%s %s does not exist", name, m[1])
+	}
+	return name + ": " + msg
+}
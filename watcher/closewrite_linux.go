@@ -0,0 +1,124 @@
+//go:build linux
+
+package watcher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// closeWriter reports files in watched directories that were closed after
+// being written (IN_CLOSE_WRITE) or moved in complete (IN_MOVED_TO), using a
+// raw inotify instance alongside fsnotify, which does not surface these.
+type closeWriter struct {
+	fd     int
+	f      *os.File // wraps fd; calling f.Fd() would make it blocking
+	events chan string
+	errs   chan error
+	done   chan struct{}
+
+	mu  sync.Mutex
+	wds map[int32]string // watch descriptor → directory
+}
+
+// newCloseWriter starts an inotify instance and its read loop.
+func newCloseWriter() (*closeWriter, error) {
+	// A non-blocking descriptor goes through the runtime poller, so Close
+	// interrupts a pending Read.
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init: %w", err)
+	}
+	c := &closeWriter{
+		fd:     fd,
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan string),
+		errs:   make(chan error),
+		done:   make(chan struct{}),
+		wds:    make(map[int32]string),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Add watches dir for files closed after writing or moved in.
+func (c *closeWriter) Add(dir string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wd, err := syscall.InotifyAddWatch(c.fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO)
+	if err != nil {
+		return fmt.Errorf("inotify watch %s: %w", dir, err)
+	}
+	c.wds[int32(wd)] = dir
+	return nil
+}
+
+// Events delivers absolute paths of finished files.
+func (c *closeWriter) Events() <-chan string { return c.events }
+
+// Errors delivers read errors; the read loop stops after the first one.
+func (c *closeWriter) Errors() <-chan error { return c.errs }
+
+// Close stops the read loop and releases the inotify instance.
+func (c *closeWriter) Close() error {
+	close(c.done)
+	return c.f.Close()
+}
+
+// readLoop decodes inotify events until Close is called.
+func (c *closeWriter) readLoop() {
+	var buf [64 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)]byte
+	for {
+		n, err := c.f.Read(buf[:])
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+			select {
+			case c.errs <- fmt.Errorf("inotify read: %w", err):
+			case <-c.done:
+			}
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				slog.Warn("inotify queue overflowed, some finished files may only be seen by the debounce or poll")
+				continue
+			}
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				c.mu.Lock()
+				delete(c.wds, ev.Wd)
+				c.mu.Unlock()
+				continue
+			}
+			if ev.Mask&syscall.IN_ISDIR != 0 || ev.Len == 0 {
+				continue
+			}
+			c.mu.Lock()
+			dir, ok := c.wds[ev.Wd]
+			c.mu.Unlock()
+			if !ok {
+				continue
+			}
+
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			select {
+			case c.events <- filepath.Join(dir, name):
+			case <-c.done:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package watcher
+
+import "errors"
+
+// closeWriter is only implemented on Linux; elsewhere Watch falls back to the
+// debounce.
+type closeWriter struct{}
+
+func newCloseWriter() (*closeWriter, error) {
+	return nil, errors.New("close-write events are only supported on Linux")
+}
+
+func (c *closeWriter) Add(dir string) error  { return nil }
+func (c *closeWriter) Events() <-chan string { return nil }
+func (c *closeWriter) Errors() <-chan error  { return nil }
+func (c *closeWriter) Close() error          { return nil }
@@ -0,0 +1,143 @@
+package watcher
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IgnoreFile is the name of the per-directory ignore file read from the root
+// of every watch directory.
+const IgnoreFile = ".paperlessignore"
+
+// ignoreList holds the patterns of a watch directory's IgnoreFile. Each
+// non-blank line that does not start with '#' is a path.Match glob:
+//
+//   - a pattern without a slash matches any file or directory name, at any
+//     depth, so "*.jpg" or "thumbnails" work anywhere below the directory;
+//   - a trailing slash, as in "thumbnails/", only matches directories;
+//   - any other slash anchors the pattern to the watch directory, as in
+//     "/inbox/*.pdf" or "2024/drafts".
+//
+// A file is ignored if it, or one of the directories it is in, matches.
+// Negation is not supported.
+type ignoreList struct {
+	dir      string
+	patterns []ignorePattern
+
+	// size and mod identify the loaded version of the file; a zero mod means
+	// there was none.
+	size int64
+	mod  time.Time
+}
+
+type ignorePattern struct {
+	glob     string
+	anchored bool
+	dirOnly  bool
+}
+
+// loadIgnore reads dir's IgnoreFile, if there is one.
+func loadIgnore(dir string) *ignoreList {
+	l := &ignoreList{dir: dir}
+	l.refresh()
+	return l
+}
+
+// refresh re-reads the ignore file if it was created, removed or changed
+// since it was last loaded. A file that cannot be read keeps the previous
+// patterns.
+func (l *ignoreList) refresh() {
+	file := filepath.Join(l.dir, IgnoreFile)
+	info, err := os.Stat(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		if !l.mod.IsZero() {
+			slog.Info("ignore file removed, no longer ignoring files", "file", file)
+		}
+		l.patterns, l.size, l.mod = nil, 0, time.Time{}
+		return
+	}
+	if err != nil {
+		slog.Warn("cannot read ignore file", "file", file, "error", err)
+		return
+	}
+	if info.Size() == l.size && info.ModTime().Equal(l.mod) {
+		return
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		slog.Warn("cannot read ignore file", "file", file, "error", err)
+		return
+	}
+	defer f.Close()
+
+	var patterns []ignorePattern
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := ignorePattern{glob: line}
+		if strings.HasSuffix(p.glob, "/") {
+			p.glob, p.dirOnly = strings.TrimRight(p.glob, "/"), true
+		}
+		if strings.Contains(p.glob, "/") {
+			p.glob, p.anchored = strings.TrimLeft(p.glob, "/"), true
+		}
+		if _, err := path.Match(p.glob, ""); err != nil || p.glob == "" {
+			slog.Warn("ignore file: skipping invalid pattern", "file", file, "line", n, "pattern", line)
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	if err := sc.Err(); err != nil {
+		slog.Warn("cannot read ignore file", "file", file, "error", err)
+		return
+	}
+
+	l.patterns, l.size, l.mod = patterns, info.Size(), info.ModTime()
+	slog.Info("loaded ignore file", "file", file, "patterns", len(patterns))
+}
+
+// isIgnoreFile reports whether file is the ignore file itself.
+func (l *ignoreList) isIgnoreFile(file string) bool {
+	return file == filepath.Join(l.dir, IgnoreFile)
+}
+
+// ignores reports whether file, an absolute path inside the watch directory,
+// matches one of the patterns.
+func (l *ignoreList) ignores(file string) bool {
+	if len(l.patterns) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(l.dir, file)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for _, p := range l.patterns {
+		// Every part but the last is a directory the file is in.
+		last := len(parts)
+		if p.dirOnly {
+			last--
+		}
+		for i := 0; i < last; i++ {
+			subject := parts[i]
+			if p.anchored {
+				subject = strings.Join(parts[:i+1], "/")
+			}
+			if ok, _ := path.Match(p.glob, subject); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package watcher
+
+// isLocked reports whether err means another process holds the file locked.
+// Only Windows enforces such locks on open.
+func isLocked(err error) bool { return false }
@@ -0,0 +1,15 @@
+//go:build windows
+
+package watcher
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// isLocked reports whether err is Windows refusing to open a file another
+// process has open without sharing it, or has locked a range of.
+func isLocked(err error) bool {
+	return errors.Is(err, windows.ERROR_SHARING_VIOLATION) || errors.Is(err, windows.ERROR_LOCK_VIOLATION)
+}
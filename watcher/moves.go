@@ -0,0 +1,103 @@
+package watcher
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// minMoveWindow is the least time a file that left its path is remembered
+// for, so that it turning up elsewhere in the tree is recognised as a move.
+const minMoveWindow = time.Minute
+
+// moveWindow is how long a file that left its path is remembered: long
+// enough for its new path to get through the debounce, stability and wait
+// checks, as reported by the slowest source.
+func (opts *Options) moveWindow() time.Duration {
+	return max(minMoveWindow, opts.dedupeWindow()+opts.FileWaitTimeout)
+}
+
+// moves remembers the files a watcher has emitted and that were uploaded, so
+// that one moved or renamed to another path in the tree is not uploaded
+// again; a file whose upload failed is not remembered. A move keeps
+// the file's identity, its inode or on Windows its file ID, which a new copy
+// with the same content does not share. It is only used from the watcher's
+// goroutine.
+type moves struct {
+	window time.Duration
+
+	// emitted maps the path of every uploaded file still there to its Stat
+	// when it was emitted.
+	emitted map[string]fs.FileInfo
+
+	// departed are uploaded files that left their path, oldest first.
+	departed []departure
+}
+
+type departure struct {
+	path string
+	info fs.FileInfo
+	at   time.Time
+}
+
+func newMoves(window time.Duration) *moves {
+	return &moves{window: window, emitted: make(map[string]fs.FileInfo)}
+}
+
+// add records that the file at path, described by info, was uploaded.
+func (m *moves) add(path string, info fs.FileInfo) {
+	// On Windows a FileInfo loads its file ID lazily, by path; have it do so
+	// while the path still exists, so it can be compared after a move.
+	os.SameFile(info, info)
+	m.emitted[path] = info
+}
+
+// removed notes that path was deleted, so its file cannot turn up again.
+func (m *moves) removed(path string) {
+	delete(m.emitted, path)
+}
+
+// left notes that path was renamed or disappeared, so its file may turn up
+// elsewhere. For a directory this applies to every uploaded file below it.
+func (m *moves) left(path string) {
+	now := time.Now()
+	if info, ok := m.emitted[path]; ok {
+		delete(m.emitted, path)
+		m.departed = append(m.departed, departure{path: path, info: info, at: now})
+		return
+	}
+	prefix := path + string(filepath.Separator)
+	for p, info := range m.emitted {
+		if strings.HasPrefix(p, prefix) {
+			delete(m.emitted, p)
+			m.departed = append(m.departed, departure{path: p, info: info, at: now})
+		}
+	}
+}
+
+// match reports whether the file at path, described by info, is an uploaded
+// file that left its earlier path, which it returns, unchanged. It is then
+// remembered under path.
+func (m *moves) match(path string, info fs.FileInfo) (string, bool) {
+	for i, d := range m.departed {
+		if os.SameFile(d.info, info) && d.info.Size() == info.Size() && d.info.ModTime().Equal(info.ModTime()) {
+			m.departed = slices.Delete(m.departed, i, i+1)
+			m.add(path, info)
+			return d.path, true
+		}
+	}
+	return "", false
+}
+
+// expire forgets files that left their path longer than the window ago,
+// as when they were moved out of the tree.
+func (m *moves) expire() {
+	n := 0
+	for n < len(m.departed) && time.Since(m.departed[n].at) > m.window {
+		n++
+	}
+	m.departed = m.departed[n:]
+}
@@ -0,0 +1,79 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMovedFile(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		uploaded bool
+		want     bool // emitted again after the rename
+	}{
+		{"uploaded", true, false},
+		{"failed", false, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, files := watchTest(t, Options{})
+			from, to := filepath.Join(dir, "a.pdf"), filepath.Join(dir, "b.pdf")
+			writeFile(t, from, "content")
+
+			f := receive(t, files)
+			if f.Path != from {
+				t.Fatalf("got %s, want %s", f.Path, from)
+			}
+			f.Done(tt.uploaded)
+			// Let the watcher take in the outcome before the rename.
+			time.Sleep(100 * time.Millisecond)
+
+			if err := os.Rename(from, to); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.want {
+				noFile(t, files)
+				return
+			}
+			if f := receive(t, files); f.Path != to {
+				t.Fatalf("got %s, want %s", f.Path, to)
+			}
+		})
+	}
+}
+
+func TestMovesMatch(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "a.pdf"), filepath.Join(dir, "b.pdf")
+	writeFile(t, from, "content")
+	info, err := os.Stat(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMoves(time.Minute)
+	m.add(from, info)
+	m.left(from)
+	if err := os.Rename(from, to); err != nil {
+		t.Fatal(err)
+	}
+	moved, err := os.Stat(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := m.match(to, moved); !ok || got != from {
+		t.Errorf("match = %q, %v; want %q, true", got, ok, from)
+	}
+
+	// A new file with the same content is not the same file.
+	writeFile(t, from, "content")
+	copied, err := os.Stat(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.left(to)
+	if _, ok := m.match(from, copied); ok {
+		t.Error("copy matched as a move")
+	}
+}
@@ -0,0 +1,111 @@
+package watcher
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// walkFunc is called by walkTree for every directory and regular file it
+// reaches, with the path as seen from the watch directory and the Stat of
+// what it names; info is nil when err is set.
+type walkFunc func(path string, info fs.FileInfo, err error) error
+
+// walkTree calls fn for start, which is root or a directory below it, and for
+// the regular files in it; with opts.Recursive also for every directory and
+// file further down. fn may return fs.SkipDir for a directory.
+//
+// Symlinks are skipped unless opts.FollowSymlinks is set. A followed link to
+// a directory is walked under the link's own path, but only if its target
+// neither lies inside nor contains root or a directory already followed, so
+// a link cycle cannot loop and no file is reached under two names.
+func walkTree(root, start string, opts *Options, fn walkFunc) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fn(start, nil, err)
+	}
+	walked := []string{realRoot}
+
+	// walk walks the directory real, which contains no symlinks, reporting
+	// each path below it as the same path below shown.
+	var walk func(real, shown string) error
+
+	follow := func(link, shown string) error {
+		if !opts.FollowSymlinks {
+			slog.Debug("skipping symlink", "path", shown)
+			return nil
+		}
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			slog.Debug("skipping broken symlink", "path", shown, "error", err)
+			return nil
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() {
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			return fn(shown, info, nil)
+		}
+		if !opts.Recursive {
+			return nil
+		}
+		for _, w := range walked {
+			if within(w, target) || within(target, w) {
+				slog.Debug("not following symlink to a directory that is already watched",
+					"path", shown,
+					"target", target,
+				)
+				return nil
+			}
+		}
+		walked = append(walked, target)
+		return walk(target, shown)
+	}
+
+	walk = func(real, shown string) error {
+		return filepath.WalkDir(real, func(path string, d fs.DirEntry, err error) error {
+			rel, _ := filepath.Rel(real, path)
+			p := filepath.Join(shown, rel)
+			if err != nil {
+				return fn(p, nil, err)
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				return follow(path, p)
+			}
+			if d.IsDir() && path != real && !opts.Recursive {
+				return fs.SkipDir
+			}
+			if !d.IsDir() && !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				// Gone since the directory was read.
+				return nil
+			}
+			return fn(p, info, nil)
+		})
+	}
+
+	if start != root {
+		if info, err := os.Lstat(start); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return follow(start, start)
+		}
+	}
+	realStart, err := filepath.EvalSymlinks(start)
+	if err != nil {
+		return fn(start, nil, err)
+	}
+	return walk(realStart, start)
+}
+
+// within reports whether path is dir or inside it; both must be clean.
+func within(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
@@ -0,0 +1,126 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// symlinkTree builds a watch directory with a file in a subdirectory, a
+// link from the subdirectory back up to the root, one from the root into the
+// subdirectory, and a link to a directory outside.
+//
+//	root/sub/a.pdf
+//	root/sub/up -> root
+//	root/down -> root/sub
+//	root/out -> outside, holding b.pdf
+func symlinkTree(t *testing.T) (root string) {
+	t.Helper()
+	root, outside := t.TempDir(), t.TempDir()
+	sub := filepath.Join(root, "sub")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(sub, "a.pdf"), "a")
+	writeFile(t, filepath.Join(outside, "b.pdf"), "b")
+	for link, target := range map[string]string{
+		filepath.Join(sub, "up"):    root,
+		filepath.Join(root, "down"): sub,
+		filepath.Join(root, "out"):  outside,
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+	return root
+}
+
+func TestScanSymlinkCycle(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		follow bool
+		want   []string
+	}{
+		{"skipped", false, []string{"sub/a.pdf"}},
+		{"followed", true, []string{"out/b.pdf", "sub/a.pdf"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := symlinkTree(t)
+			done := make(chan []File)
+			go func() {
+				files, err := Scan(Options{Dir: root, Recursive: true, FollowSymlinks: tt.follow})
+				if err != nil {
+					t.Error(err)
+				}
+				done <- files
+			}()
+			var files []File
+			select {
+			case files = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("scan did not finish; caught in a symlink cycle?")
+			}
+
+			var got []string
+			for _, f := range files {
+				rel, _ := filepath.Rel(root, f.Path)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("scan found %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWatchSymlinkCycle watches a tree with a link cycle: starting must not
+// loop, and a new file must be emitted once, under its real path.
+func TestWatchSymlinkCycle(t *testing.T) {
+	root := symlinkTree(t)
+	_, files := watchTest(t, Options{Dir: root, Recursive: true, FollowSymlinks: true})
+
+	path := filepath.Join(root, "sub", "c.pdf")
+	writeFile(t, path, "c")
+	if f := receive(t, files); f.Path != path {
+		t.Fatalf("got %s, want %s", f.Path, path)
+	}
+	noFile(t, files)
+}
+
+// TestScanMinAge scans an old file, a new one and one modified in the
+// future: without MinAge all are found, with it only the old one.
+func TestScanMinAge(t *testing.T) {
+	dir := t.TempDir()
+	for name, mod := range map[string]time.Time{
+		"old.pdf":    time.Now().Add(-time.Hour),
+		"new.pdf":    time.Now(),
+		"future.pdf": time.Now().Add(time.Hour),
+	} {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, name)
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		minAge time.Duration
+		want   []string
+	}{
+		{0, []string{"future.pdf", "new.pdf", "old.pdf"}},
+		{time.Minute, []string{"old.pdf"}},
+	} {
+		files, err := Scan(Options{Dir: dir, MinAge: tt.minAge})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range files {
+			got = append(got, filepath.Base(f.Path))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("min-age %v: scan found %q, want %q", tt.minAge, got, tt.want)
+		}
+	}
+}
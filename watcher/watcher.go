@@ -1,20 +1,195 @@
 // Package watcher monitors a directory for newly created files and emits their
 // paths on a channel. It uses fsnotify for native OS events and optionally
-// filters by file extension. A generation-based debounce avoids duplicate
-// events from rapid write bursts (e.g. large file copies).
+// filters by file extension, name globs and a per-directory ignore file. A
+// generation-based debounce avoids duplicate events from rapid write bursts
+// (e.g. large file copies); files renamed away before their debounce fires,
+// as in atomic saves, are dropped. On Linux, inotify close-write events can
+// take the place of the debounce. A periodic directory scan can run alongside
+// (or instead of) fsnotify for filesystems that do not deliver reliable
+// events, such as NFS or SMB mounts.
 package watcher
 
 import (
+	"errors"
+	"expvar"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
-const debounceDelay = 750 * time.Millisecond
+// trackedPaths counts the paths with a pending debounce or stability check
+// across all watchers. It is published through expvar, e.g. on the
+// -pprof-addr listener under /debug/vars.
+var trackedPaths = expvar.NewInt("watcher_tracked_paths")
+
+// backlogFiles counts the files across all watchers that are ready for upload
+// but waiting because the output channel is full.
+var backlogFiles = expvar.NewInt("watcher_backlog_files")
+
+// backlogWarnAfter is how long the output channel must stay full before the
+// watcher warns that uploads are falling behind.
+const backlogWarnAfter = time.Minute
+
+// backlogPerQueued is how many files a watcher holds back, per file of
+// QueueSize, before it drops new ones until the backlog drains.
+const backlogPerQueued = 64
+
+// tempSuffixes mark files that browsers, scanners and sync tools write before
+// renaming them to their final name.
+var tempSuffixes = []string{".part", ".tmp", ".crdownload", "~"}
+
+// dirCheckInterval is how often the watch directory itself is checked, so a
+// deleted directory or dropped mount is noticed and watched again once it is
+// back.
+const dirCheckInterval = 10 * time.Second
+
+// closeWriteDelay is how long a file is held after its close-write event, so
+// that the rename of an atomic save, which follows right after, cancels it.
+const closeWriteDelay = 100 * time.Millisecond
+
+// Options controls how a directory is watched.
+type Options struct {
+	// Dir is the directory to watch.
+	Dir string
+
+	// AllowedExts returns the allowed extensions; it is called for every
+	// file so the set can change while watching. It may be nil, or return an
+	// empty set, to allow all extensions.
+	AllowedExts func() map[string]struct{}
+
+	// Include and Exclude are filepath.Match globs on the file name. An empty
+	// Include allows every name; Exclude wins over Include.
+	Include []string
+	Exclude []string
+
+	// IgnoreTemp skips hidden files (leading dot) and files ending in one of
+	// the usual temporary-file suffixes.
+	IgnoreTemp bool
+
+	// Notify enables native fsnotify events.
+	Notify bool
+
+	// Debounce is how long a path must be free of new events before it is
+	// checked; bursts of writes within it collapse into one.
+	Debounce time.Duration
+
+	// Poll enables a periodic os.ReadDir scan every PollInterval.
+	Poll         bool
+	PollInterval time.Duration
+
+	// ProcessExisting emits files already present in Dir at startup.
+	ProcessExisting bool
+
+	// StabilityInterval is the quiet period a file's size and modtime must stay
+	// unchanged for before it is emitted. Zero disables the check.
+	StabilityInterval time.Duration
+
+	// QueueSize is how many files ready for upload the returned channel
+	// buffers; see backlogLimit for those that do not fit.
+	QueueSize int
+
+	// FileWaitTimeout is how long a file that cannot be accessed when its
+	// debounce fires, as on slow network storage, is retried before it is
+	// skipped.
+	FileWaitTimeout time.Duration
+
+	// MinAge is how long ago a file must last have been modified before it
+	// is emitted; younger files are checked again once they are old enough.
+	// A file modified in the future is emitted MinAge after it was first
+	// seen. Zero disables the check.
+	MinAge time.Duration
+
+	// Recursive also watches every subdirectory of Dir, including ones
+	// created while running.
+	Recursive bool
+
+	// FollowSymlinks uploads files that are symlinks and, with Recursive,
+	// descends into symlinked directories, skipping any whose target is
+	// already watched so that link cycles are harmless. Without it symlinks
+	// are ignored.
+	FollowSymlinks bool
+
+	// Rescan, when it receives, makes the watcher scan Dir again and check
+	// every file in it as if it had just appeared, regardless of
+	// ProcessExisting. It may be nil.
+	Rescan <-chan struct{}
+
+	// CloseWrite, with Notify on Linux, handles a file as soon as its writer
+	// closes it or it is moved in, instead of Debounce after its last create
+	// or write event. Other platforms fall back to the debounce.
+	CloseWrite bool
+}
+
+// File is a file ready for upload.
+type File struct {
+	// Path is the absolute path of the file.
+	Path string
+
+	// Dir is the absolute watch directory the file was found in, which may
+	// be an ancestor of Path's directory when watching recursively.
+	Dir string
+
+	// done reports the outcome back to the watcher that emitted the file;
+	// nil for files from anywhere else.
+	done func(uploaded bool)
+}
+
+// Done tells the watcher that emitted f whether f was uploaded, or found in
+// Paperless already, and need not be uploaded again. Only such a file is
+// recognised when it is later moved within the tree; a failed one is
+// uploaded again under its new path. It is safe to call on any File, even
+// after the watcher has stopped; not calling it counts as a failure.
+func (f File) Done(uploaded bool) {
+	if f.done != nil {
+		f.done(uploaded)
+	}
+}
+
+// fileState is a size/modtime snapshot used by the stability check.
+type fileState struct {
+	size int64
+	mod  time.Time
+}
+
+// futureMod records when a file with a modification time in the future was
+// first seen, which stands in for its modification time in the MinAge check.
+type futureMod struct {
+	mod  time.Time // the future modification time
+	seen time.Time
+}
+
+// emitted records when a file was sent and what it looked like then, for the
+// duplicate check.
+type emitted struct {
+	at    time.Time
+	state fileState
+}
+
+// dedupeWindow is how long an emitted file is suppressed if it is reported
+// again unchanged. It spans two rounds of the slowest source, plus the
+// stability check, so that fsnotify and the poller reporting the same file
+// one round apart still only emit it once.
+func (opts *Options) dedupeWindow() time.Duration {
+	round := opts.Debounce
+	if opts.Poll {
+		round = max(round, opts.PollInterval)
+	}
+	return 2*round + opts.StabilityInterval
+}
+
+// backlogLimit is how many files ready for upload a watcher holds back while
+// the output channel is full. That bounds its memory when uploads lag behind
+// by far; files beyond it are found again by a rescan once the backlog has
+// halved.
+func (opts *Options) backlogLimit() int {
+	return backlogPerQueued * max(opts.QueueSize, 1)
+}
 
 // debounceMsg is sent by a timer goroutine back into the main select loop via
 // a dedicated channel, keeping all map operations on a single goroutine.
@@ -23,26 +198,108 @@ type debounceMsg struct {
 	gen  int
 }
 
-// Watch starts watching dir and sends absolute paths of newly created / written
-// files to the returned channel. It stops when stop is closed.
-// allowedExts may be nil/empty to allow all extensions.
-func Watch(dir string, allowedExts map[string]struct{}, stop <-chan struct{}) (<-chan string, error) {
-	out := make(chan string, 16)
+// Watch starts watching opts.Dir and sends absolute paths of newly created /
+// written files to the returned channel, buffered for opts.QueueSize files.
+// Files that do not fit wait in the watcher, which keeps reading events
+// meanwhile. It stops when stop is closed.
+func Watch(opts Options, stop <-chan struct{}) (<-chan File, error) {
+	out := make(chan File, max(opts.QueueSize, 0))
 
-	fw, err := fsnotify.NewWatcher()
+	dir, err := filepath.Abs(opts.Dir)
 	if err != nil {
 		return nil, err
 	}
-	if err := fw.Add(dir); err != nil {
-		_ = fw.Close()
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
 		return nil, err
 	}
 
-	slog.Info("watching directory", "dir", dir)
+	// A nil channel blocks forever in select, so disabled sources simply
+	// never fire.
+	var (
+		fw       *fsnotify.Watcher
+		events   <-chan fsnotify.Event
+		errs     <-chan error
+		cw       *closeWriter
+		closed   <-chan string
+		cwErrs   <-chan error
+		pollTick <-chan time.Time
+	)
+
+	// add registers a directory with every event source in use.
+	add := func(path string) error {
+		if err := fw.Add(path); err != nil {
+			return err
+		}
+		if cw != nil {
+			return cw.Add(path)
+		}
+		return nil
+	}
+
+	if opts.Notify {
+		fw, err = fsnotify.NewWatcher()
+		if err != nil {
+			return nil, err
+		}
+		if opts.CloseWrite {
+			if cw, err = newCloseWriter(); err != nil {
+				slog.Warn("cannot use close-write events, falling back to debounce", "dir", dir, "error", err)
+				cw = nil
+			} else {
+				closed, cwErrs = cw.Events(), cw.Errors()
+			}
+		}
+		if err := addWatches(add, dir, dir, &opts); err != nil {
+			_ = fw.Close()
+			if cw != nil {
+				_ = cw.Close()
+			}
+			return nil, err
+		}
+		events, errs = fw.Events, fw.Errors
+	}
+
+	var ticker *time.Ticker
+	if opts.Poll {
+		ticker = time.NewTicker(opts.PollInterval)
+		pollTick = ticker.C
+	}
+
+	slog.Info("watching directory",
+		"dir", dir,
+		"fsnotify", opts.Notify,
+		"poll", opts.Poll,
+		"recursive", opts.Recursive,
+		"close_write", cw != nil,
+	)
+
+	// The ignore file is only touched from the goroutine below from now on.
+	ignore := loadIgnore(dir)
+	existing := scanDir(dir, dir, &opts)
+
+	// seen records the modtime of every file already emitted (or ignored at
+	// startup) so the poller does not re-emit files fsnotify already reported.
+	seen := make(map[string]time.Time)
+	if opts.Poll && !opts.ProcessExisting {
+		for path, mod := range existing {
+			seen[path] = mod
+		}
+	}
 
 	go func() {
 		defer close(out)
-		defer fw.Close()
+		if fw != nil {
+			defer fw.Close()
+		}
+		defer func() {
+			if cw != nil {
+				_ = cw.Close()
+			}
+		}()
+		if ticker != nil {
+			defer ticker.Stop()
+		}
 
 		// timerCh is the only channel that timer goroutines write to.
 		// All map state is accessed exclusively from within this goroutine.
@@ -50,41 +307,328 @@ func Watch(dir string, allowedExts map[string]struct{}, stop <-chan struct{}) (<
 
 		timers := make(map[string]*time.Timer) // path → active timer
 		gens := make(map[string]int)           // path → current generation
+		states := make(map[string]fileState)   // path → last stability snapshot
+		future := make(map[string]futureMod)   // path → modified in the future
+		sent := make(map[string]emitted)       // path → last emit, within dedupe
+
+		// reported is this watcher's share of trackedPaths.
+		var reported int
+
+		// backlog holds the files ready for upload that did not fit into
+		// out yet, oldest first, so a slow uploader never stops the loop
+		// from draining events before the kernel's queue overflows.
+		// backlogSince is when out last became full, and warned is set
+		// once that was reported. Beyond backlogLimit files are dropped,
+		// and dropped is set until a rescan has picked them up again.
+		var (
+			backlog         []File
+			backlogSince    time.Time
+			warned          bool
+			dropped         bool
+			reportedBacklog int // this watcher's share of backlogFiles
+		)
+		maxBacklog := opts.backlogLimit()
+		defer func() { backlogFiles.Add(int64(-reportedBacklog)) }()
+
+		dedupe := opts.dedupeWindow()
+		moved := newMoves(opts.moveWindow())
+
+		// uploaded receives the files reported by File.Done as uploaded,
+		// with their Stat when they were emitted.
+		uploaded := make(chan departure, 64)
+
+		dirCheck := time.NewTicker(dirCheckInterval)
+		defer dirCheck.Stop()
+
+		// Stop every pending timer on the way out, whichever way the loop
+		// ends, so none fires after nobody reads timerCh any more.
+		defer func() {
+			for _, t := range timers {
+				t.Stop()
+			}
+			trackedPaths.Add(int64(-reported))
+		}()
+
+		scheduleAfter := func(path string, delay time.Duration) {
+			// Cancel any existing timer for this path.
+			if t, ok := timers[path]; ok {
+				t.Stop()
+			}
+
+			// Bump generation; the timer goroutine captures this value.
+			gens[path]++
+			gen := gens[path]
+			p := path
+
+			// Timer goroutine only touches timerCh – safe.
+			timers[p] = time.AfterFunc(delay, func() {
+				select {
+				case timerCh <- debounceMsg{path: p, gen: gen}:
+				case <-stop:
+				}
+			})
+		}
+		schedule := func(path string) { scheduleAfter(path, opts.Debounce) }
+
+		// forget drops any pending debounce or stability check for a path that
+		// no longer exists; a timer already in flight fails the gens check.
+		forget := func(path string) {
+			if t, ok := timers[path]; ok {
+				t.Stop()
+			}
+			delete(timers, path)
+			delete(gens, path)
+			delete(states, path)
+			delete(future, path)
+		}
+
+		// checkDir notices when dir has disappeared, or was replaced as by a
+		// mount coming or going, and watches it afresh once it is there. It
+		// logs an error on every check while dir is missing.
+		missing := false
+		checkDir := func() {
+			info, err := os.Stat(dir)
+			if err == nil && !info.IsDir() {
+				err = errors.New("not a directory")
+			}
+			if err != nil {
+				slog.Error("watch directory missing, waiting for it to come back", "dir", dir, "error", err, "retry", dirCheckInterval)
+				missing = true
+				return
+			}
+			if !missing && os.SameFile(info, dirInfo) {
+				return
+			}
+			if fw != nil {
+				if err := addWatches(add, dir, dir, &opts); err != nil {
+					slog.Error("cannot watch directory again, retrying", "dir", dir, "error", err, "retry", dirCheckInterval)
+					missing = true
+					return
+				}
+			}
+			if missing {
+				slog.Warn("watch directory is back, watching again", "dir", dir)
+			} else {
+				slog.Warn("watch directory was replaced, e.g. by a mount change; watching the new one", "dir", dir)
+			}
+			missing, dirInfo = false, info
+			ignore.refresh()
+			if opts.ProcessExisting {
+				for path := range scanDir(dir, dir, &opts) {
+					if _, pending := gens[path]; !pending {
+						schedule(path)
+					}
+				}
+			}
+		}
+
+		// Files dropped while PaperlessLink was down go through the same
+		// debounce, filter and stability checks as live events.
+		if opts.ProcessExisting {
+			if len(existing) > 0 {
+				slog.Info("queuing existing files", "dir", dir, "count", len(existing))
+			}
+			for path := range existing {
+				schedule(path)
+			}
+		}
 
 		for {
+			// Every path with a pending check has a generation.
+			if n := len(gens); n != reported {
+				trackedPaths.Add(int64(n - reported))
+				reported = n
+			}
+			if n := len(backlog); n != reportedBacklog {
+				backlogFiles.Add(int64(n - reportedBacklog))
+				reportedBacklog = n
+			}
+			// A nil channel never receives, so without a backlog there is
+			// nothing to send.
+			var send chan<- File
+			var next File
+			if len(backlog) > 0 {
+				send, next = out, backlog[0]
+			}
+
 			select {
 			case <-stop:
 				return
 
-			case event, ok := <-fw.Events:
+			case send <- next:
+				backlog = backlog[1:]
+				if len(backlog) == 0 {
+					if warned {
+						slog.Info("upload backlog cleared", "dir", dir)
+					}
+					backlog, backlogSince, warned = nil, time.Time{}, false
+				}
+				// A dropped file was not sent as it is now and has no check
+				// pending; everything else is left alone.
+				if dropped && len(backlog) <= maxBacklog/2 && !missing {
+					dropped = false
+					current := scanDir(dir, dir, &opts)
+					slog.Info("upload backlog halved, rescanning for dropped files", "dir", dir, "count", len(current))
+					for path, mod := range current {
+						if _, pending := gens[path]; pending {
+							continue
+						}
+						if e, ok := sent[path]; ok && e.state.mod.Equal(mod) {
+							continue
+						}
+						schedule(path)
+					}
+				}
+
+			case event, ok := <-events:
 				if !ok {
 					return
 				}
+				path, err := filepath.Abs(event.Name)
+				if err != nil {
+					continue
+				}
+				if path == dir {
+					if event.Op&(fsnotify.Rename|fsnotify.Remove) != 0 {
+						checkDir()
+					}
+					continue
+				}
+				if ignore.isIgnoreFile(path) {
+					ignore.refresh()
+					continue
+				}
+				// An atomic save writes a temp file and renames it into place:
+				// fsnotify reports Rename for the temp name and Create, with no
+				// Write, for the final one. Cancel the checks on the old name;
+				// the Create below starts them afresh on the final path.
+				if event.Op&(fsnotify.Rename|fsnotify.Remove) != 0 {
+					if _, pending := gens[path]; pending {
+						slog.Debug("file renamed or removed before upload", "file", path)
+						forget(path)
+					}
+					if event.Op&fsnotify.Rename != 0 {
+						moved.left(path)
+					} else {
+						moved.removed(path)
+					}
+				}
 				if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
 					continue
 				}
-				path, err := filepath.Abs(event.Name)
-				if err != nil {
+				if info, err := os.Stat(path); err == nil && info.IsDir() {
+					if !opts.Recursive || event.Op&fsnotify.Create == 0 {
+						continue
+					}
+					// Watch the new subdirectory and pick up anything that
+					// landed in it before the watch was registered.
+					if err := addWatches(add, dir, path, &opts); err != nil {
+						slog.Warn("cannot watch new subdirectory", "dir", path, "error", err)
+					}
+					for p := range scanDir(dir, path, &opts) {
+						schedule(p)
+					}
 					continue
 				}
+				// With close-write events the file is handled once its
+				// writer is done, not while it is still being written.
+				if cw != nil {
+					continue
+				}
+				schedule(path)
 
-				// Cancel any existing timer for this path.
-				if t, ok := timers[path]; ok {
-					t.Stop()
+			case path := <-closed:
+				if ignore.isIgnoreFile(path) {
+					ignore.refresh()
+					continue
 				}
+				slog.Debug("file closed after writing or moved in", "file", path)
+				scheduleAfter(path, closeWriteDelay)
 
-				// Bump generation; the timer goroutine captures this value.
-				gens[path]++
-				gen := gens[path]
-				p := path
+			case cwErr := <-cwErrs:
+				// Keep going on fsnotify events alone.
+				slog.Error("close-write watcher failed, falling back to debounce", "dir", dir, "error", cwErr)
+				_ = cw.Close()
+				cw, closed, cwErrs = nil, nil, nil
 
-				// Timer goroutine only touches timerCh – safe.
-				timers[p] = time.AfterFunc(debounceDelay, func() {
-					select {
-					case timerCh <- debounceMsg{path: p, gen: gen}:
-					case <-stop:
+			case <-dirCheck.C:
+				checkDir()
+				if !warned && len(backlog) > 0 && time.Since(backlogSince) > backlogWarnAfter {
+					slog.Warn("uploads are falling behind, files waiting for a free upload slot",
+						"dir", dir,
+						"waiting", len(backlog),
+						"since", backlogSince,
+					)
+					warned = true
+				}
+				for path, e := range sent {
+					if time.Since(e.at) > dedupe {
+						delete(sent, path)
+					}
+				}
+				moved.expire()
+				// Catches edits fsnotify does not report, as on network
+				// filesystems.
+				ignore.refresh()
+
+			case u := <-uploaded:
+				// Only a file still where it was uploaded from can move on
+				// from there; one already deleted or moved away cannot.
+				if info, err := os.Stat(u.path); err == nil && os.SameFile(info, u.info) {
+					moved.add(u.path, u.info)
+				}
+
+			case <-opts.Rescan:
+				if missing {
+					slog.Warn("watch directory missing, cannot rescan", "dir", dir)
+					continue
+				}
+				ignore.refresh()
+				current := scanDir(dir, dir, &opts)
+				slog.Info("rescanning directory", "dir", dir, "count", len(current))
+				for path := range current {
+					if _, pending := gens[path]; !pending {
+						// Asked for explicitly, so not a duplicate event.
+						delete(sent, path)
+						schedule(path)
 					}
-				})
+				}
+
+			case <-pollTick:
+				// An empty scan of a missing directory would make the poller
+				// forget every file it has seen.
+				if !missing {
+					checkDir()
+				}
+				if missing {
+					continue
+				}
+				ignore.refresh()
+				current := scanDir(dir, dir, &opts)
+				for path, mod := range current {
+					if prev, ok := seen[path]; ok && prev.Equal(mod) {
+						continue
+					}
+					if _, pending := timers[path]; pending {
+						continue
+					}
+					slog.Debug("poll found new or changed file", "file", path)
+					schedule(path)
+				}
+				// Forget files that have disappeared so a later file with the
+				// same name is treated as new.
+				for path := range seen {
+					if _, ok := current[path]; !ok {
+						delete(seen, path)
+					}
+				}
+				// Without fsnotify, a vanished file is the only sign of a
+				// move.
+				for path := range moved.emitted {
+					if _, ok := current[path]; !ok {
+						moved.left(path)
+					}
+				}
 
 			case msg := <-timerCh:
 				// Discard if a newer event has superseded this one.
@@ -94,26 +638,140 @@ func Watch(dir string, allowedExts map[string]struct{}, stop <-chan struct{}) (<
 				delete(timers, msg.path)
 				delete(gens, msg.path)
 
-				if !allowed(msg.path, allowedExts) {
-					slog.Debug("skipping file (extension not allowed)", "file", msg.path)
+				if reason := opts.skipReason(msg.path, ignore); reason != "" {
+					slog.Debug("skipping file ("+reason+")", "file", msg.path)
+					delete(states, msg.path)
+					delete(future, msg.path)
 					continue
 				}
-				if err := waitForFile(msg.path, 2*time.Second); err != nil {
-					slog.Warn("file not accessible, skipping", "file", msg.path, "error", err)
+				// Short-lived files are common (scanner temp files, editors);
+				// drop everything kept for a path that is gone.
+				if err := waitForFile(msg.path, opts.FileWaitTimeout, stop); err != nil {
+					if errors.Is(err, errStopped) {
+						slog.Debug("stopped while waiting for file", "file", msg.path)
+						return
+					}
+					if isLocked(err) {
+						// Still being written; check again rather than
+						// skipping a file that will be fine in a moment.
+						slog.Debug("file locked by another process, waiting", "file", msg.path, "error", err)
+						scheduleAfter(msg.path, opts.Debounce)
+						continue
+					}
+					if errors.Is(err, fs.ErrNotExist) {
+						slog.Debug("file gone before upload, skipping", "file", msg.path)
+					} else {
+						slog.Warn("file not accessible, skipping", "file", msg.path, "waited", opts.FileWaitTimeout, "error", err)
+					}
+					forget(msg.path)
 					continue
 				}
+				if !opts.FollowSymlinks {
+					if li, err := os.Lstat(msg.path); err == nil && li.Mode()&fs.ModeSymlink != 0 {
+						slog.Debug("skipping file (symlink)", "file", msg.path)
+						forget(msg.path)
+						continue
+					}
+				}
+				info, err := os.Stat(msg.path)
+				if err != nil || !info.Mode().IsRegular() {
+					forget(msg.path)
+					continue
+				}
+
+				// A writer that pauses for longer than the stability interval
+				// still has to leave the file alone for MinAge. A modification
+				// time in the future, from clock skew on a NAS or a scanner
+				// with a wrong clock, says nothing about how long ago the file
+				// was written, so its age counts from when it was first seen.
+				if opts.MinAge > 0 {
+					age := time.Since(info.ModTime())
+					if age < 0 {
+						fm, ok := future[msg.path]
+						if !ok || !fm.mod.Equal(info.ModTime()) {
+							slog.Warn("file modified in the future, check the clocks; waiting min-age from now", "file", msg.path, "modified", info.ModTime(), "min_age", opts.MinAge)
+							fm = futureMod{mod: info.ModTime(), seen: time.Now()}
+							future[msg.path] = fm
+						}
+						age = time.Since(fm.seen)
+					}
+					if age < opts.MinAge {
+						slog.Debug("file modified too recently, waiting", "file", msg.path, "age", age.Round(time.Millisecond), "min_age", opts.MinAge)
+						scheduleAfter(msg.path, opts.MinAge-age)
+						continue
+					}
+				}
+
+				// Only emit once two snapshots StabilityInterval apart agree;
+				// otherwise take a new snapshot and check again later. This
+				// avoids blocking the loop while a slow copy is in progress.
+				cur := fileState{size: info.Size(), mod: info.ModTime()}
+				if opts.StabilityInterval > 0 {
+					if prev, ok := states[msg.path]; !ok || prev != cur {
+						if ok {
+							slog.Debug("file still changing, waiting", "file", msg.path, "size", cur.size)
+						}
+						states[msg.path] = cur
+						scheduleAfter(msg.path, opts.StabilityInterval)
+						continue
+					}
+					delete(states, msg.path)
+				}
+
+				if len(backlog) >= maxBacklog {
+					if !dropped {
+						slog.Warn("upload backlog full, dropping files until it has halved; a rescan picks them up then",
+							"dir", dir,
+							"limit", maxBacklog,
+						)
+						dropped = true
+					}
+					slog.Debug("upload backlog full, dropping file", "file", msg.path)
+					continue
+				}
+				if opts.Poll {
+					seen[msg.path] = info.ModTime()
+				}
+				// The same file can reach here twice, for example from a late
+				// fsnotify event and the poller; a file that changed since is
+				// new content and goes through again.
+				if e, ok := sent[msg.path]; ok && e.state == cur && time.Since(e.at) < dedupe {
+					slog.Debug("file already queued, skipping duplicate event", "file", msg.path)
+					continue
+				}
+				if from, ok := moved.match(msg.path, info); ok {
+					slog.Info("file moved within the watch directory, not uploading it again", "file", msg.path, "from", from)
+					continue
+				}
+				delete(future, msg.path)
+				sent[msg.path] = emitted{at: time.Now(), state: cur}
 				slog.Info("new file detected, queuing upload", "file", msg.path)
-				select {
-				case out <- msg.path:
-				case <-stop:
-					return
+				f := File{Path: msg.path, Dir: dir}
+				f.done = func(ok bool) {
+					if !ok {
+						return
+					}
+					select {
+					case uploaded <- departure{path: f.Path, info: info}:
+					case <-stop:
+					}
+				}
+				if len(backlog) == 0 {
+					select {
+					case out <- f:
+						continue
+					default:
+						backlogSince = time.Now()
+					}
 				}
+				backlog = append(backlog, f)
 
-			case watchErr, ok := <-fw.Errors:
+			case watchErr, ok := <-errs:
 				if !ok {
 					return
 				}
 				slog.Error("watcher error", "error", watchErr)
+				checkDir()
 			}
 		}
 	}()
@@ -121,6 +779,104 @@ func Watch(dir string, allowedExts map[string]struct{}, stop <-chan struct{}) (<
 	return out, nil
 }
 
+// Scan returns the files currently in opts.Dir, and below it with
+// opts.Recursive, that pass the extension, temp-file and name filters and
+// are at least opts.MinAge old, sorted by path. It is the one-off counterpart
+// of Watch: no debounce or stability check is applied, and a file modified
+// in the future counts as modified just now.
+func Scan(opts Options) ([]File, error) {
+	dir, err := filepath.Abs(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.ReadDir(dir); err != nil {
+		return nil, err
+	}
+	ignore := loadIgnore(dir)
+	var files []File
+	for path, mod := range scanDir(dir, dir, &opts) {
+		if reason := opts.skipReason(path, ignore); reason != "" {
+			slog.Debug("skipping file ("+reason+")", "file", path)
+			continue
+		}
+		if opts.MinAge > 0 {
+			if age := time.Since(mod); age < 0 {
+				slog.Warn("skipping file modified in the future, check the clocks", "file", path, "modified", mod, "min_age", opts.MinAge)
+				continue
+			} else if age < opts.MinAge {
+				slog.Info("skipping file modified too recently", "file", path, "min_age", opts.MinAge)
+				continue
+			}
+		}
+		files = append(files, File{Path: path, Dir: dir})
+	}
+	slices.SortFunc(files, func(a, b File) int { return strings.Compare(a.Path, b.Path) })
+	return files, nil
+}
+
+// skipReason returns why path is filtered out, or "" if it passes.
+func (opts *Options) skipReason(path string, ignore *ignoreList) string {
+	switch name := filepath.Base(path); {
+	case ignore.isIgnoreFile(path):
+		return "ignore file"
+	case ignore.ignores(path):
+		return "listed in " + IgnoreFile
+	case opts.AllowedExts != nil && !allowed(path, opts.AllowedExts()):
+		return "extension not allowed"
+	case opts.IgnoreTemp && isTemp(name):
+		return "hidden or temporary"
+	case !nameAllowed(name, opts.Include, opts.Exclude):
+		return "excluded by name filter"
+	}
+	return ""
+}
+
+// addWatches registers start, dir or a directory below it, with add and,
+// with opts.Recursive, every directory below start, following symlinks as
+// walkTree does.
+func addWatches(add func(string) error, dir, start string, opts *Options) error {
+	return walkTree(dir, start, opts, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			if path == start {
+				return err
+			}
+			slog.Warn("cannot walk directory", "dir", path, "error", err)
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if err := add(path); err != nil {
+			if path == start {
+				return err
+			}
+			slog.Warn("cannot watch subdirectory", "dir", path, "error", err)
+			return nil
+		}
+		slog.Debug("watching subdirectory", "dir", path)
+		return nil
+	})
+}
+
+// scanDir returns the absolute path and modtime of every regular file inside
+// start, dir or a directory below it, descending into subdirectories with
+// opts.Recursive. Read errors are logged and the unreadable part of the tree is
+// skipped.
+func scanDir(dir, start string, opts *Options) map[string]time.Time {
+	result := make(map[string]time.Time)
+	_ = walkTree(dir, start, opts, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			slog.Warn("scan: cannot read directory", "dir", path, "error", err)
+			return nil
+		}
+		if !info.IsDir() {
+			result[path] = info.ModTime()
+		}
+		return nil
+	})
+	return result
+}
+
 // allowed returns true if the path's extension is in the allowed set,
 // or if the allowed set is empty (all extensions permitted).
 func allowed(path string, exts map[string]struct{}) bool {
@@ -132,15 +888,81 @@ func allowed(path string, exts map[string]struct{}) bool {
 	return ok
 }
 
-// waitForFile blocks until the file at path exists and is readable (or timeout).
-func waitForFile(path string, timeout time.Duration) error {
-	deadline := time.Now().Add(timeout)
-	for time.Now().Before(deadline) {
-		if _, err := os.Stat(path); err == nil {
+// isTemp reports whether name is a hidden file or has a temporary-file suffix.
+func isTemp(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	lower := strings.ToLower(name)
+	for _, suffix := range tempSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// nameAllowed reports whether name matches one of the include globs (or
+// include is empty) and none of the exclude globs. The patterns are checked
+// at startup, so match errors are ignored.
+func nameAllowed(name string, include, exclude []string) bool {
+	for _, p := range exclude {
+		if ok, _ := filepath.Match(p, name); ok {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, p := range include {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// openable checks that path can be opened for reading by opening and closing
+// it. Anything but a regular file passes unopened, as opening a FIFO would
+// block; the caller skips those.
+func openable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// errStopped is returned by waitForFile when stop is closed while waiting.
+var errStopped = errors.New("watcher stopped")
+
+// waitForFile blocks until the file at path exists and can be opened for
+// reading, returning the last error once timeout has passed, or errStopped as
+// soon as stop is closed. A file still held open exclusively by its writer,
+// as scanners on Windows do, is not ready yet.
+func waitForFile(path string, timeout time.Duration, stop <-chan struct{}) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		err := openable(path)
+		if err == nil {
 			return nil
 		}
-		time.Sleep(100 * time.Millisecond)
+		select {
+		case <-stop:
+			return errStopped
+		case <-deadline.C:
+			return err
+		case <-tick.C:
+		}
 	}
-	_, err := os.Stat(path)
-	return err
 }
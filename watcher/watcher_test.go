@@ -0,0 +1,180 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// watchTest starts a fsnotify watcher on opts.Dir, or a new temp directory,
+// with short delays and returns the directory and the watcher's output; the
+// watcher is stopped when the test ends.
+func watchTest(t *testing.T, opts Options) (string, <-chan File) {
+	t.Helper()
+	if opts.Dir == "" {
+		opts.Dir = t.TempDir()
+	}
+	dir := opts.Dir
+	opts.Notify = true
+	if opts.Debounce == 0 {
+		opts.Debounce = 50 * time.Millisecond
+	}
+	stop := make(chan struct{})
+	files, err := Watch(opts, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		close(stop)
+		for range files {
+		}
+	})
+	return dir, files
+}
+
+// receive returns the next file from files, failing the test if none comes
+// within a second.
+func receive(t *testing.T, files <-chan File) File {
+	t.Helper()
+	select {
+	case f := <-files:
+		return f
+	case <-time.After(time.Second):
+		t.Fatal("no file emitted")
+		return File{}
+	}
+}
+
+// noFile fails the test if files emits anything within half a second.
+func noFile(t *testing.T, files <-chan File) {
+	t.Helper()
+	select {
+	case f := <-files:
+		t.Fatalf("unexpected file emitted: %s", f.Path)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAtomicSave writes a temp file and renames it to its final name, as
+// editors and scanners do: only the final name is emitted, once.
+func TestAtomicSave(t *testing.T) {
+	dir, files := watchTest(t, Options{})
+	tmp, final := filepath.Join(dir, "doc.pdf.tmp"), filepath.Join(dir, "doc.pdf")
+	writeFile(t, tmp, "content")
+	if err := os.Rename(tmp, final); err != nil {
+		t.Fatal(err)
+	}
+
+	if f := receive(t, files); f.Path != final {
+		t.Fatalf("got %s, want %s", f.Path, final)
+	}
+	noFile(t, files)
+}
+
+// TestStopLeaksNoGoroutines stops a watcher while debounce and stability
+// checks are pending and the poller runs, then waits past the debounce: no
+// goroutine of the watcher, its timers or fsnotify may be left.
+func TestStopLeaksNoGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	dir := t.TempDir()
+	stop := make(chan struct{})
+	files, err := Watch(Options{
+		Dir:               dir,
+		Notify:            true,
+		Poll:              true,
+		PollInterval:      50 * time.Millisecond,
+		Debounce:          200 * time.Millisecond,
+		StabilityInterval: 200 * time.Millisecond,
+	}, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More than timerCh buffers, so fired timers would block on it.
+	for i := range 100 {
+		writeFile(t, filepath.Join(dir, fmt.Sprintf("scan%d.pdf", i)), "content")
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	for range files {
+	}
+
+	// Give timers that were pending time to fire, as they would if
+	// stopping had not stopped them.
+	time.Sleep(300 * time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines before, %d after stopping:\n%s",
+				before, runtime.NumGoroutine(), buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestFutureModTime gives a new file a modification time an hour ahead, as
+// clock skew on network storage does: without MinAge it is emitted as usual,
+// and with MinAge once MinAge has passed since it was seen, not in an hour.
+func TestFutureModTime(t *testing.T) {
+	for _, minAge := range []time.Duration{0, 300 * time.Millisecond} {
+		t.Run(fmt.Sprintf("min-age %v", minAge), func(t *testing.T) {
+			dir, files := watchTest(t, Options{MinAge: minAge})
+			path := filepath.Join(dir, "scan.pdf")
+			writeFile(t, path, "content")
+			future := time.Now().Add(time.Hour)
+			if err := os.Chtimes(path, future, future); err != nil {
+				t.Fatal(err)
+			}
+			if f := receive(t, files); f.Path != path {
+				t.Fatalf("got %s, want %s", f.Path, path)
+			}
+			noFile(t, files)
+		})
+	}
+}
+
+// TestBacklogLimit lets more files pile up than the backlog holds while
+// nothing reads them: the backlog stops at its limit, and every file still
+// comes through in the end, the dropped ones by the rescan.
+func TestBacklogLimit(t *testing.T) {
+	opts := Options{QueueSize: 1}
+	limit := opts.backlogLimit()
+	total := opts.QueueSize + 2*limit
+	dir, files := watchTest(t, opts)
+	for i := range total {
+		writeFile(t, filepath.Join(dir, fmt.Sprintf("scan%03d.pdf", i)), "content")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for backlogFiles.Value() < int64(limit) {
+		if time.Now().After(deadline) {
+			t.Fatalf("backlog at %d, want it to fill up to %d", backlogFiles.Value(), limit)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if n := backlogFiles.Value(); n != int64(limit) {
+		t.Fatalf("backlog at %d, want it capped at %d", n, limit)
+	}
+
+	got := make(map[string]bool)
+	for len(got) < total {
+		f := receive(t, files)
+		if got[f.Path] {
+			t.Fatalf("%s emitted twice", f.Path)
+		}
+		got[f.Path] = true
+	}
+	noFile(t, files)
+}